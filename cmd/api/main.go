@@ -1,34 +1,157 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
-	"path/filepath"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+
 	"simple_load_balancer/config"
+	"simple_load_balancer/internal/logging"
 	"simple_load_balancer/internal/server"
+	"simple_load_balancer/internal/upgrade"
 )
 
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// defaultConfigPath is used when neither -config nor LB_CONFIG is given
+const defaultConfigPath = "config/config.json"
+
 func main() {
+	// Variables in a .env file are added to the environment, without
+	// replacing variables that are already set
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Failed to load .env file: %v", err)
+	}
+
+	configFlag := flag.String("config", envOr("LB_CONFIG", defaultConfigPath), "path to the configuration file (env LB_CONFIG)")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration file and exit")
+	printConfig := flag.String("print-config", "", "print the effective configuration, with overrides and defaults applied and secrets masked, as json, yaml or toml and exit")
+	// Every setting can be overridden with a flag or an LB_* environment
+	// variable; flags take precedence over the environment, which takes
+	// precedence over the file
+	flagOverrides := config.FromFlags(flag.CommandLine)
+	flag.Parse()
+	overrides := []*config.Overrides{config.FromEnv(), flagOverrides}
+
 	cwd, err := os.Getwd()
 	if err != nil {
-			log.Fatalf("Failed to get current working directory: %v", err)
+		log.Fatalf("Failed to get current working directory: %v", err)
 	}
-	log.Printf("Current working directory: %s", cwd)
+	logging.Debugf("Current working directory: %s", cwd)
 
 	// Get the absolute path to the config file
-	configPath, err := filepath.Abs("config/config.json")
+	configPath, err := filepath.Abs(*configFlag)
 	if err != nil {
-			log.Fatalf("Failed to get absolute path to config file: %v", err)
+		log.Fatalf("Failed to get absolute path to config file: %v", err)
 	}
-	log.Printf("Config file path: %s", configPath)
+	logging.Infof("Config file path: %s", configPath)
 
-	cfg, err := config.Load(configPath)
+	cfg, err := config.Load(configPath, overrides...)
+	if *checkConfig || *printConfig != "" {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+			os.Exit(1)
+		}
+		if *checkConfig {
+			fmt.Printf("%s: configuration OK\n", configPath)
+			return
+		}
+		data, err := config.Marshal(cfg.Redacted(), *printConfig)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+		return
+	}
 	if err != nil {
-			log.Fatalf("Failed to load configuration: %v", err)
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := logging.Configure(server.LoggingConfig(cfg)); err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
 	}
 
+	// Stop gracefully on Ctrl+C and on SIGTERM from process managers
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGUSR2 hands the listening sockets to a freshly started binary
+	upgradeCh := make(chan os.Signal, 1)
+	upgrade.Notify(upgradeCh)
+
+	// Reload the configuration on SIGHUP or when the file changes on disk
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	configChanged := config.Watch(ctx, configPath, configPollInterval)
+
 	s := server.New(cfg)
-	if err := s.Start(); err != nil {
-			log.Fatalf("Server failed to start: %v", err)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start()
+	}()
+
+	for {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				log.Fatalf("Server failed to start: %v", err)
+			}
+			return
+		case <-reloadCh:
+			logging.Infof("Received reload signal")
+			cfg = reload(s, configPath, overrides, cfg)
+		case <-configChanged:
+			logging.Infof("Config file changed")
+			cfg = reload(s, configPath, overrides, cfg)
+		case <-upgradeCh:
+			logging.Infof("Received upgrade signal")
+			if err := s.Upgrade(); err != nil {
+				logging.Errorf("Upgrade failed, continuing to serve: %v", err)
+			}
+		case <-ctx.Done():
+			stop() // A second signal kills the process immediately
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+			defer cancel()
+			if err := s.Shutdown(shutdownCtx); err != nil {
+				logging.Errorf("Graceful shutdown incomplete: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// envOr returns the value of the environment variable name, or fallback if it is not set
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// reload reads the config file again and applies it to the running server,
+// keeping the environment and command-line overrides given at startup. It
+// returns the configuration now in effect, which is still current if the
+// file could not be loaded or was rejected.
+func reload(s *server.Server, configPath string, overrides []*config.Overrides, current *config.Config) *config.Config {
+	cfg, err := config.Load(configPath, overrides...)
+	if err == nil {
+		err = s.Reload(cfg)
+	}
+	if err != nil {
+		logging.Errorf("Rejected new configuration, keeping the current one: %v", err)
+		return current
 	}
-}
\ No newline at end of file
+	return cfg
+}
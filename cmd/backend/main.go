@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"flag"
 	"fmt"
+	"strconv"
+	"sync/atomic"
 	"simple_load_balancer/internal/models"
 	"simple_load_balancer/internal/database"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,10 +15,21 @@ import (
 
 var db *mongo.Database
 
+// inFlight counts the requests currently being handled and capacity is the
+// number of concurrent requests this server treats as 100% load
+var (
+	inFlight atomic.Int64
+	capacity int
+)
+
 func main() {
 	// Define a flag for the port
 	port := flag.Int("port", 8080, "port to run the server on")
+	flag.IntVar(&capacity, "capacity", 100, "number of concurrent requests reported as 100% load")
 	flag.Parse()
+	if capacity <= 0 {
+		log.Fatalf("capacity must be positive, got %d", capacity)
+	}
 
 	var err error
 	db, err = database.ConnectMongoDB("mongodb://localhost:27017", "userdb")
@@ -24,7 +37,7 @@ func main() {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 
-	http.HandleFunc("/users", handleUsers)
+	http.HandleFunc("/users", reportLoad(handleUsers))
 	http.HandleFunc("/health", handleHealth)
 
 	addr := fmt.Sprintf(":%d", *port)
@@ -66,5 +79,26 @@ func handleUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "healthy", "load": currentLoad()})
+}
+
+// reportLoad counts the request as in flight and reports the server's load
+// to the load balancer in the X-Backend-Load response header
+func reportLoad(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		w.Header().Set("X-Backend-Load", strconv.FormatFloat(currentLoad(), 'f', 1, 64))
+		next(w, r)
+	}
+}
+
+// currentLoad returns the in-flight requests as a percentage of capacity
+func currentLoad() float64 {
+	load := float64(inFlight.Load()) / float64(capacity) * 100
+	if load > 100 {
+		load = 100
+	}
+	return load
 }
\ No newline at end of file
@@ -2,127 +2,418 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"reflect"
 	"time"
-	"errors"
 )
 
 // Config holds the configuration for the load balancer
 type Config struct {
 	// Server settings
 	ListenAddr string `json:"listen_addr"`
+	// Address of the admin API, served separately from proxied traffic
+	AdminAddr string `json:"admin_addr"`
+	// Bearer token required by the admin API; without it the API is read-only
+	AdminToken string `json:"admin_token"`
+	// How long a backend being removed may take to finish in-flight requests
+	DrainTimeout Duration `json:"drain_timeout"`
+	// How long shutdown waits for in-flight requests before exiting anyway
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
 
 	MongoURI string `json:"mongo_uri"`
 	MongoDB  string `json:"mongo_db"`
-	
+
 	// TLS settings
 	TLSCertFile string `json:"tls_cert_file"`
 	TLSKeyFile  string `json:"tls_key_file"`
-	
+
+	// HTTP server settings for proxied traffic. The read and write timeouts
+	// are disabled when unset so long uploads and downloads are not cut off.
+	HTTPReadTimeout       Duration `json:"http_read_timeout"`
+	HTTPReadHeaderTimeout Duration `json:"http_read_header_timeout"`
+	HTTPWriteTimeout      Duration `json:"http_write_timeout"`
+	// How long a client connection may stay idle before and between requests
+	HTTPIdleTimeout    Duration `json:"http_idle_timeout"`
+	HTTPMaxHeaderBytes int      `json:"http_max_header_bytes"`
+
 	// Connection pool settings
 	PoolMaxConns        int      `json:"pool_max_conns"`
 	PoolIdleTimeout     Duration `json:"pool_idle_timeout"`
 	PoolMaxLifetime     Duration `json:"pool_max_lifetime"`
 	PoolCleanupInterval Duration `json:"pool_cleanup_interval"`
-	
+	// How long a request waits for a connection when a backend is at
+	// pool_max_conns (0 fails it straight away)
+	PoolWaitTimeout Duration `json:"pool_wait_timeout"`
+	// Connections pre-dialed to each backend when it is added (0 disables)
+	PoolMinIdle int `json:"pool_min_idle"`
+
+	// Retry settings. Connection failures and the retry_on_status responses
+	// are retried on another backend, at most retry_max_attempts backends
+	// per request in all, while retries stay within retry_budget_percent of
+	// requests. Requests with bodies up to retry_buffer_bytes are buffered so
+	// they can be retried whatever their method (0 disables buffering).
+	RetryMaxAttempts   int     `json:"retry_max_attempts"`
+	RetryOnStatus      []int   `json:"retry_on_status"`
+	RetryBudgetPercent float64 `json:"retry_budget_percent"`
+	RetryBufferBytes   int     `json:"retry_buffer_bytes"`
+
 	// Health checker settings
 	HealthCheckInterval Duration `json:"health_check_interval"`
 	HealthCheckTimeout  Duration `json:"health_check_timeout"`
-	HealthCheckEndpoint string   `json:"health_check_endpoint"`
-	
+	// Deprecated: set the path of the "default" entry in HealthChecks instead
+	HealthCheckEndpoint string `json:"health_check_endpoint"`
+	// Named health checks; backends select one with their health_check field
+	// and use "default" otherwise
+	HealthChecks map[string]HealthCheck `json:"health_checks"`
+	// Consecutive successes needed to mark a backend up, and failures to mark it down
+	HealthCheckRise int `json:"health_check_rise"`
+	HealthCheckFall int `json:"health_check_fall"`
+	// Upper bound for exponential backoff of checks against dead backends (0 disables backoff)
+	HealthCheckMaxBackoff Duration `json:"health_check_max_backoff"`
+	// Random spread applied to check timing, as a fraction of the interval
+	HealthCheckJitter float64 `json:"health_check_jitter"`
+
+	// Outlier detection settings
+	OutlierConsecutiveErrors  int      `json:"outlier_consecutive_errors"`
+	OutlierBaseEjectionTime   Duration `json:"outlier_base_ejection_time"`
+	OutlierMaxEjectionTime    Duration `json:"outlier_max_ejection_time"`
+	OutlierMaxEjectionPercent int      `json:"outlier_max_ejection_percent"`
+
 	// Registry settings
 	RegistryFile string `json:"registry_file"`
-	
+
 	// Balancer settings
 	BalancerAlgorithm string `json:"balancer_algorithm"`
-	
+	HashKey           string `json:"hash_key"`
+	HashVirtualNodes  int    `json:"hash_virtual_nodes"`
+
+	// Backend-reported load settings
+	LoadHeader     string   `json:"load_header"`
+	LoadStaleAfter Duration `json:"load_stale_after"`
+
 	// Logging settings
 	LogLevel  string `json:"log_level"`
 	LogFormat string `json:"log_format"`
 
 	// Backend servers
-	BackendServers []string `json:"backend_servers"`
+	BackendServers []BackendServer `json:"backend_servers"`
+}
+
+// HealthCheck describes how a group of backends is probed and what a
+// healthy answer looks like
+type HealthCheck struct {
+	// Type is "http" (the default) or "grpc" for the gRPC health checking protocol
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	// Scheme is "http" or "https"
+	Scheme string `json:"scheme"`
+	// Accepted status codes: single codes ("204"), ranges ("200-299") or classes ("2xx")
+	ExpectedStatus []string          `json:"expected_status"`
+	Headers        map[string]string `json:"headers"`
+	BodyContains   string            `json:"body_contains"`
+	BodyRegex      string            `json:"body_regex"`
+	// Dotted path into a JSON body that must equal json_value (or just exist if it is empty)
+	JSONPath  string `json:"json_path"`
+	JSONValue string `json:"json_value"`
+	// Service name for gRPC probes and whether to dial them over TLS
+	GRPCService           string `json:"grpc_service"`
+	GRPCTLS               bool   `json:"grpc_tls"`
+	TLSCAFile             string `json:"tls_ca_file"`
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify"`
+}
+
+// BackendServer describes a backend listed in the configuration file.
+// It can be written either as a plain "host:port" string or as an object
+// with an address, an optional weight and an optional health check name.
+type BackendServer struct {
+	Address     string `json:"address"`
+	Weight      int    `json:"weight,omitempty"`
+	HealthCheck string `json:"health_check,omitempty"`
+}
+
+func (s *BackendServer) UnmarshalJSON(b []byte) error {
+	var address string
+	if err := json.Unmarshal(b, &address); err == nil {
+		*s = BackendServer{Address: address}
+		return nil
+	}
+
+	// Decode through an alias type so this method is not called recursively
+	type backendServer BackendServer
+	var v backendServer
+	err := json.Unmarshal(b, &v)
+	*s = BackendServer(v) // keep the fields that did decode
+	return err
 }
 
 type Duration time.Duration
 
 func (d *Duration) UnmarshalJSON(b []byte) error {
-		var v interface{}
-		if err := json.Unmarshal(b, &v); err != nil {
-				return err
-		}
-		switch value := v.(type) {
-		case float64:
-				*d = Duration(time.Duration(value))
-				return nil
-		case string:
-				tmp, err := time.ParseDuration(value)
-				if err != nil {
-						return err
-				}
-				*d = Duration(tmp)
-				return nil
-		default:
-				return errors.New("invalid duration")
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value))
+		return nil
+	case string:
+		tmp, err := time.ParseDuration(value)
+		if err != nil {
+			return err
 		}
+		*d = Duration(tmp)
+		return nil
+	default:
+		return errors.New("invalid duration")
+	}
 }
 
-// Load retrieves the configuration from a JSON file
-func Load(filePath string) (*Config, error) {
-	file, err := os.Open(filePath)
+// String formats the duration like time.Duration does
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Load retrieves the configuration from a JSON, YAML or TOML file, chosen
+// by its extension, applies overrides on
+// top of it and validates the result. Later overrides take precedence over
+// earlier ones, and any override over the file. If the file parses but is
+// invalid, the error is a *ValidationError listing every problem, including
+// values of the wrong type and fields that are not part of the configuration.
+func Load(filePath string, overrides ...*Overrides) (*Config, error) {
+	format, err := FormatOf(filePath)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	config := &Config{}
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(config)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
+	if data, err = toJSON(data, format); err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	var ps problems
+	decode(&ps, data, reflect.ValueOf(config).Elem(), "")
+	undecoded := make([]string, len(ps))
+	for i, p := range ps {
+		undecoded[i] = p.Path
+	}
+
+	// Look for misspelled or unsupported settings, which would otherwise be
+	// silently ignored
+	checkUnknownFields(&ps, raw, reflect.TypeOf(config), "")
+
+	for _, o := range overrides {
+		o.apply(config, &ps)
+	}
 
 	// Set default values for fields that are not specified
 	config.setDefaults()
 
+	// Values that could not be decoded were left empty; do not report them
+	// a second time as out of range
+	if err := config.Validate(); err != nil {
+		for _, p := range err.(*ValidationError).Problems {
+			if !within(p.Path, undecoded) {
+				ps = append(ps, p)
+			}
+		}
+	}
+	if err := ps.err(); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
+// decode unmarshals data into v one field, element or map entry at a time,
+// so a value of the wrong type is reported as a problem at its JSON path and
+// the rest of the document is still decoded and checked. Types with their
+// own UnmarshalJSON are decoded whole.
+func decode(ps *problems, data json.RawMessage, v reflect.Value, path string) {
+	if _, custom := v.Addr().Interface().(json.Unmarshaler); !custom {
+		switch v.Kind() {
+		case reflect.Struct:
+			var object map[string]json.RawMessage
+			if json.Unmarshal(data, &object) == nil && object != nil {
+				for i := 0; i < v.NumField(); i++ {
+					field := v.Type().Field(i)
+					if value, ok := object[fieldName(field)]; ok && field.IsExported() {
+						decode(ps, value, v.Field(i), join(path, fieldName(field)))
+					}
+				}
+				return
+			}
+		case reflect.Slice:
+			var array []json.RawMessage
+			if json.Unmarshal(data, &array) == nil && array != nil {
+				v.Set(reflect.MakeSlice(v.Type(), len(array), len(array)))
+				for i, element := range array {
+					decode(ps, element, v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+				}
+				return
+			}
+		case reflect.Map:
+			var object map[string]json.RawMessage
+			if v.Type().Key().Kind() == reflect.String && json.Unmarshal(data, &object) == nil && object != nil {
+				m := reflect.MakeMapWithSize(v.Type(), len(object))
+				for _, key := range sortedKeys(object) {
+					element := reflect.New(v.Type().Elem()).Elem()
+					decode(ps, object[key], element, join(path, key))
+					m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), element)
+				}
+				v.Set(m)
+				return
+			}
+		}
+	}
+
+	err := json.Unmarshal(data, v.Addr().Interface())
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		// Field is set when the error is inside a type with its own UnmarshalJSON
+		if typeErr.Field != "" {
+			path = join(path, typeErr.Field)
+		}
+		ps.add(path, "expected %s, got %s", jsonType(typeErr.Type), typeErr.Value)
+	case err != nil:
+		ps.add(path, "%v", err)
+	}
+}
+
+// jsonType describes the JSON value that decodes into t
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
 // setDefaults sets default values for configuration fields that are not specified
 func (c *Config) setDefaults() {
 	if c.ListenAddr == "" {
 		c.ListenAddr = ":8080"
 	}
+	if c.AdminAddr == "" {
+		c.AdminAddr = "127.0.0.1:9090"
+	}
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = Duration(30 * time.Second)
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = Duration(30 * time.Second)
+	}
 	if len(c.BackendServers) == 0 {
-		c.BackendServers = []string{"localhost:8081"} // Set a default backend server
+		c.BackendServers = []BackendServer{{Address: "localhost:8081"}} // Set a default backend server
 	}
 
 	if c.MongoURI == "" {
 		c.MongoURI = "mongodb://localhost:27017"
-}
-if c.MongoDB == "" {
+	}
+	if c.MongoDB == "" {
 		c.MongoDB = "userdb"
-}
+	}
+	if c.HTTPReadHeaderTimeout == 0 {
+		c.HTTPReadHeaderTimeout = Duration(10 * time.Second)
+	}
+	if c.HTTPIdleTimeout == 0 {
+		c.HTTPIdleTimeout = Duration(2 * time.Minute)
+	}
+	if c.HTTPMaxHeaderBytes == 0 {
+		c.HTTPMaxHeaderBytes = 1 << 20
+	}
 	if c.PoolMaxConns == 0 {
 		c.PoolMaxConns = 100
 	}
 	if c.PoolIdleTimeout == 0 {
 		c.PoolIdleTimeout = Duration(5 * time.Minute)
-}
-if c.PoolMaxLifetime == 0 {
+	}
+	if c.PoolMaxLifetime == 0 {
 		c.PoolMaxLifetime = Duration(30 * time.Minute)
-}
-if c.PoolCleanupInterval == 0 {
+	}
+	if c.PoolCleanupInterval == 0 {
 		c.PoolCleanupInterval = Duration(1 * time.Minute)
-}
-if c.HealthCheckInterval == 0 {
+	}
+	if c.RetryMaxAttempts == 0 {
+		c.RetryMaxAttempts = 2
+	}
+	if c.RetryOnStatus == nil {
+		c.RetryOnStatus = []int{502, 503, 504}
+	}
+	if c.RetryBudgetPercent == 0 {
+		c.RetryBudgetPercent = 20
+	}
+	if c.HealthCheckInterval == 0 {
 		c.HealthCheckInterval = Duration(10 * time.Second)
-}
-if c.HealthCheckTimeout == 0 {
+	}
+	if c.HealthCheckTimeout == 0 {
 		c.HealthCheckTimeout = Duration(5 * time.Second)
-}
-	if c.HealthCheckEndpoint == "" {
-		c.HealthCheckEndpoint = "/health"
+	}
+	if c.HealthChecks == nil {
+		c.HealthChecks = make(map[string]HealthCheck)
+	}
+	if _, ok := c.HealthChecks["default"]; !ok {
+		path := c.HealthCheckEndpoint
+		if path == "" {
+			path = "/health"
+		}
+		c.HealthChecks["default"] = HealthCheck{Path: path}
+	}
+	for name, check := range c.HealthChecks {
+		if check.Type == "" {
+			check.Type = "http"
+		}
+		if check.Path == "" {
+			check.Path = "/health"
+		}
+		if check.Method == "" {
+			check.Method = "GET"
+		}
+		if check.Scheme == "" {
+			check.Scheme = "http"
+		}
+		if len(check.ExpectedStatus) == 0 {
+			check.ExpectedStatus = []string{"200"}
+		}
+		c.HealthChecks[name] = check
+	}
+	if c.HealthCheckRise == 0 {
+		c.HealthCheckRise = 2
+	}
+	if c.HealthCheckFall == 0 {
+		c.HealthCheckFall = 3
+	}
+	if c.OutlierConsecutiveErrors == 0 {
+		c.OutlierConsecutiveErrors = 5
+	}
+	if c.OutlierBaseEjectionTime == 0 {
+		c.OutlierBaseEjectionTime = Duration(30 * time.Second)
+	}
+	if c.OutlierMaxEjectionTime == 0 {
+		c.OutlierMaxEjectionTime = Duration(5 * time.Minute)
+	}
+	if c.OutlierMaxEjectionPercent == 0 {
+		c.OutlierMaxEjectionPercent = 10
 	}
 	if c.RegistryFile == "" {
 		c.RegistryFile = "registry.json"
@@ -130,10 +421,22 @@ if c.HealthCheckTimeout == 0 {
 	if c.BalancerAlgorithm == "" {
 		c.BalancerAlgorithm = "round_robin"
 	}
+	if c.HashKey == "" {
+		c.HashKey = "ip"
+	}
+	if c.HashVirtualNodes == 0 {
+		c.HashVirtualNodes = 100
+	}
+	if c.LoadHeader == "" {
+		c.LoadHeader = "X-Backend-Load"
+	}
+	if c.LoadStaleAfter == 0 {
+		c.LoadStaleAfter = Duration(30 * time.Second)
+	}
 	if c.LogLevel == "" {
 		c.LogLevel = "info"
 	}
 	if c.LogFormat == "" {
 		c.LogFormat = "text"
 	}
-}
\ No newline at end of file
+}
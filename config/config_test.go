@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeConfig writes a configuration file with the given name and contents
+// and returns its path
+func writeConfig(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		problems []Problem
+	}{
+		{
+			name: "valid",
+			file: `{"listen_addr": ":8000", "backend_servers": ["localhost:8081", {"address": "localhost:8082", "weight": 3}]}`,
+		},
+		{
+			name: "type errors with unknown fields and ranges",
+			file: `{"pool_max_conns": "abc", "pool_idel_timeout": "1s", "retry_budget_percent": 500}`,
+			problems: []Problem{
+				{"pool_max_conns", "expected an integer, got string"},
+				{"pool_idel_timeout", `unknown field, did you mean "pool_idle_timeout"?`},
+				{"retry_budget_percent", "must be above 0 and at most 100, got 500"},
+			},
+		},
+		{
+			name: "type errors inside lists and maps",
+			file: `{"retry_on_status": [502, "x"], "health_checks": {"default": {"expected_status": 200}}}`,
+			problems: []Problem{
+				{"retry_on_status[1]", "expected an integer, got string"},
+				{"health_checks.default.expected_status", "expected an array, got number"},
+			},
+		},
+		{
+			name: "type error inside a backend",
+			file: `{"backend_servers": ["localhost:8081", {"address": "localhost:8082", "weight": "3"}]}`,
+			problems: []Problem{
+				{"backend_servers[1].weight", "expected an integer, got string"},
+			},
+		},
+		{
+			name: "invalid duration",
+			file: `{"drain_timeout": "soon"}`,
+			problems: []Problem{
+				{"drain_timeout", `time: invalid duration "soon"`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, "config.json", tt.file))
+			var got []Problem
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				got = validationErr.Problems
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.problems) {
+				t.Errorf("problems = %v, want %v", got, tt.problems)
+			}
+		})
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"config.json", `{"listen_addr": ":8000", "pool_max_conns": 7}`},
+		{"config.yaml", "listen_addr: \":8000\"\npool_max_conns: 7\n"},
+		{"config.toml", "listen_addr = \":8000\"\npool_max_conns = 7\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(writeConfig(t, tt.name, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.ListenAddr != ":8000" || c.PoolMaxConns != 7 {
+				t.Errorf("listen_addr = %q, pool_max_conns = %d; want \":8000\" and 7", c.ListenAddr, c.PoolMaxConns)
+			}
+		})
+	}
+}
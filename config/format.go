@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported configuration file formats
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// FormatOf returns the configuration format of a file based on its
+// extension. Files without an extension are read as JSON.
+func FormatOf(filePath string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(filePath)); ext {
+	case "", ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	default:
+		return "", fmt.Errorf("unsupported configuration file extension %q (expected .json, .yaml, .yml or .toml)", ext)
+	}
+}
+
+// toJSON converts a YAML or TOML document into JSON so that every format
+// is decoded, checked for unknown fields and validated the same way
+func toJSON(data []byte, format string) ([]byte, error) {
+	var document interface{}
+	switch format {
+	case FormatJSON:
+		return data, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported configuration format %q", format)
+	}
+	if document == nil {
+		document = map[string]interface{}{} // an empty YAML file
+	}
+	return json.Marshal(document)
+}
+
+// redacted replaces secrets in printed configurations
+const redacted = "REDACTED"
+
+// Redacted returns a copy of c with secrets masked, for printing: the admin
+// token and the password in the MongoDB URI
+func (c *Config) Redacted() *Config {
+	masked := *c
+	if masked.AdminToken != "" {
+		masked.AdminToken = redacted
+	}
+	masked.MongoURI = redactURI(masked.MongoURI)
+	return &masked
+}
+
+// redactURI masks the password of a URI. A URI that cannot be parsed has
+// everything before the host masked, in case it holds credentials.
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		if at := strings.LastIndex(uri, "@"); at >= 0 {
+			scheme, _, _ := strings.Cut(uri, "://")
+			return scheme + "://" + redacted + uri[at:]
+		}
+		return uri
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+// Marshal encodes c in the given format. Durations are written as strings
+// such as "10s", so the output can be loaded again.
+func Marshal(c *Config, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatJSON {
+		return append(data, '\n'), nil
+	}
+
+	// Go through a generic document so YAML and TOML use the JSON names
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	document = normalize(document)
+
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(document)
+	case FormatTOML:
+		return toml.Marshal(document)
+	default:
+		return nil, fmt.Errorf("unsupported configuration format %q (expected json, yaml or toml)", format)
+	}
+}
+
+// normalize prepares a decoded JSON document for YAML and TOML encoding:
+// numbers become integers where possible and null values, which TOML
+// cannot represent, are dropped
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, element := range v {
+			if element == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = normalize(element)
+		}
+		return v
+	case []interface{}:
+		for i, element := range v {
+			v[i] = normalize(element)
+		}
+		return v
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// MarshalJSON writes the duration as a string such as "1m30s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// MarshalText writes the duration as a string such as "1m30s"
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText parses a duration string such as "1m30s"
+func (d *Duration) UnmarshalText(text []byte) error {
+	tmp, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(tmp)
+	return nil
+}
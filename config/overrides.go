@@ -0,0 +1,158 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is prepended to the upper-cased JSON name of a setting to form
+// its environment variable, e.g. LB_LISTEN_ADDR for listen_addr
+const envPrefix = "LB_"
+
+// Overrides holds settings given outside the config file, keyed by their
+// JSON name. Values are parsed like the file's values: durations as "10s",
+// lists either as JSON or as comma-separated strings, and objects as JSON.
+type Overrides struct {
+	values map[string]override
+}
+
+// override is a single setting and where it came from, for error messages
+type override struct {
+	origin string
+	value  string
+}
+
+// FromEnv returns the settings given through LB_* environment variables
+func FromEnv() *Overrides {
+	o := &Overrides{values: make(map[string]override)}
+	for _, name := range settingNames() {
+		env := EnvName(name)
+		if value, ok := os.LookupEnv(env); ok {
+			o.values[name] = override{origin: env, value: value}
+		}
+	}
+	return o
+}
+
+// FromFlags registers a flag on fs for every setting, named after its JSON
+// name with dashes, e.g. -listen-addr. The returned Overrides holds the
+// flags given on the command line once fs has been parsed.
+func FromFlags(fs *flag.FlagSet) *Overrides {
+	o := &Overrides{values: make(map[string]override)}
+	for _, name := range settingNames() {
+		fs.Var(&flagValue{overrides: o, name: name}, FlagName(name),
+			fmt.Sprintf("override %s (env %s)", name, EnvName(name)))
+	}
+	return o
+}
+
+// EnvName returns the environment variable that overrides a setting
+func EnvName(name string) string {
+	return envPrefix + strings.ToUpper(name)
+}
+
+// FlagName returns the command-line flag that overrides a setting
+func FlagName(name string) string {
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+// flagValue records a flag in its Overrides when it is set
+type flagValue struct {
+	overrides *Overrides
+	name      string
+}
+
+func (f *flagValue) String() string {
+	if f.overrides == nil {
+		return "" // the zero value flag.PrintDefaults makes
+	}
+	return f.overrides.values[f.name].value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.overrides.values[f.name] = override{origin: "-" + FlagName(f.name), value: value}
+	return nil
+}
+
+// apply sets the overridden settings on c, recording values that cannot
+// be parsed as problems
+func (o *Overrides) apply(c *Config, ps *problems) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := fieldName(v.Type().Field(i))
+		ov, ok := o.values[name]
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), ov.value); err != nil {
+			ps.add(name, "invalid value %q from %s: %v", ov.value, ov.origin, err)
+		}
+	}
+}
+
+// setField parses value into the field. Strings and numbers are used as
+// they are and everything else is decoded as JSON, falling back to a JSON
+// string so that durations can be written as 10s rather than "10s". Lists
+// that are not JSON arrays are split on commas.
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+		return nil
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		field.SetInt(int64(n))
+		return nil
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		field.SetFloat(f)
+		return nil
+	}
+
+	if field.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		// Elements that are JSON values on their own, such as status codes,
+		// are used as they are; anything else, such as host:port, is a string
+		quoteAll := field.Type().Elem().Kind() == reflect.String
+		var elements []string
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element == "" {
+				continue
+			}
+			if quoteAll || !json.Valid([]byte(element)) {
+				element = strconv.Quote(element)
+			}
+			elements = append(elements, element)
+		}
+		value = "[" + strings.Join(elements, ",") + "]"
+	}
+
+	target := reflect.New(field.Type())
+	if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+		if err := json.Unmarshal([]byte(strconv.Quote(value)), target.Interface()); err != nil {
+			return err
+		}
+	}
+	field.Set(target.Elem())
+	return nil
+}
+
+// settingNames returns the JSON names of all settings in declaration order
+func settingNames() []string {
+	t := reflect.TypeOf(Config{})
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = fieldName(t.Field(i))
+	}
+	return names
+}
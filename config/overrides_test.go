@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetField(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   string
+		value   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"string", "listen_addr", ":8000", ":8000", false},
+		{"integer", "pool_max_conns", "7", 7, false},
+		{"not an integer", "pool_max_conns", "seven", nil, true},
+		{"number", "retry_budget_percent", "12.5", 12.5, false},
+		{"duration without quotes", "drain_timeout", "10s", Duration(10 * time.Second), false},
+		{"bad duration", "drain_timeout", "soon", nil, true},
+		{
+			"comma list of addresses",
+			"backend_servers", "localhost:8081, localhost:8082",
+			[]BackendServer{{Address: "localhost:8081"}, {Address: "localhost:8082"}}, false,
+		},
+		{
+			"JSON list of backends",
+			"backend_servers", `[{"address": "localhost:8081", "weight": 2}]`,
+			[]BackendServer{{Address: "localhost:8081", Weight: 2}}, false,
+		},
+		{"comma list of numbers", "retry_on_status", "502,503", []int{502, 503}, false},
+		{"comma list with a bad number", "retry_on_status", "502,abc", nil, true},
+		{"JSON object", "health_checks", `{"default": {"path": "/up"}}`, map[string]HealthCheck{"default": {Path: "/up"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			field := fieldByName(t, c, tt.field)
+
+			err := setField(field, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setField(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(field.Interface(), tt.want) {
+				t.Errorf("setField(%q) = %#v, want %#v", tt.value, field.Interface(), tt.want)
+			}
+		})
+	}
+}
+
+// fieldByName returns the field of c with the given JSON name
+func fieldByName(t *testing.T, c *Config, name string) reflect.Value {
+	t.Helper()
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if fieldName(v.Type().Field(i)) == name {
+			return v.Field(i)
+		}
+	}
+	t.Fatalf("no field %q", name)
+	return reflect.Value{}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Changed returns the JSON names of the top-level settings that differ
+// between old and new, in the order they are declared in Config
+func Changed(old, new *Config) []string {
+	var changed []string
+	oldValue := reflect.ValueOf(old).Elem()
+	newValue := reflect.ValueOf(new).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, fieldName(oldValue.Type().Field(i)))
+		}
+	}
+	return changed
+}
+
+// CopyFrom copies the named top-level settings from src into c
+func (c *Config) CopyFrom(src *Config, names []string) {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	dst := reflect.ValueOf(c).Elem()
+	from := reflect.ValueOf(src).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if selected[fieldName(dst.Type().Field(i))] {
+			dst.Field(i).Set(from.Field(i))
+		}
+	}
+}
+
+// fieldName returns the JSON name of a Config field
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Watch polls the file at filePath every interval and signals on the
+// returned channel whenever its modification time or size changes. Polling
+// stops when ctx is done.
+func Watch(ctx context.Context, filePath string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last, _ := os.Stat(filePath)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(filePath)
+			if err != nil {
+				continue // The file may be mid-replacement; try again next time
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+
+			// Coalesce changes the reader has not picked up yet
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,381 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"simple_load_balancer/internal/balancer"
+	"simple_load_balancer/internal/health"
+	"simple_load_balancer/internal/logging"
+)
+
+// Problem is a single invalid setting, identified by its JSON path such as
+// "backend_servers[1].address" or "health_checks.default.scheme"
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration (%d problems):", len(e.Problems)))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// problems collects validation problems
+type problems []Problem
+
+func (ps *problems) add(path, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the collected problems as a *ValidationError, or nil if there are none
+func (ps problems) err() error {
+	if len(ps) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: ps}
+}
+
+// Validate checks value ranges, address syntax and the constraints between
+// settings, and returns a *ValidationError listing every problem found. It
+// expects defaults to have been applied, as Load does.
+func (c *Config) Validate() error {
+	var ps problems
+
+	// Server settings
+	checkAddress(&ps, "listen_addr", c.ListenAddr, false)
+	checkAddress(&ps, "admin_addr", c.AdminAddr, false)
+	if c.ListenAddr == c.AdminAddr {
+		ps.add("admin_addr", "must differ from listen_addr")
+	}
+	checkPositive(&ps, "drain_timeout", c.DrainTimeout)
+	checkPositive(&ps, "shutdown_timeout", c.ShutdownTimeout)
+
+	if !strings.HasPrefix(c.MongoURI, "mongodb://") && !strings.HasPrefix(c.MongoURI, "mongodb+srv://") {
+		ps.add("mongo_uri", "must start with mongodb:// or mongodb+srv://")
+	}
+
+	// TLS settings
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		ps.add("tls_key_file", "tls_cert_file and tls_key_file must be set together")
+	}
+	checkFile(&ps, "tls_cert_file", c.TLSCertFile)
+	checkFile(&ps, "tls_key_file", c.TLSKeyFile)
+
+	// HTTP server settings
+	if c.HTTPReadTimeout < 0 {
+		ps.add("http_read_timeout", "must not be negative, got %s", c.HTTPReadTimeout)
+	}
+	checkPositive(&ps, "http_read_header_timeout", c.HTTPReadHeaderTimeout)
+	if c.HTTPReadTimeout > 0 && c.HTTPReadHeaderTimeout > c.HTTPReadTimeout {
+		ps.add("http_read_header_timeout", "must not exceed http_read_timeout (%s), got %s", c.HTTPReadTimeout, c.HTTPReadHeaderTimeout)
+	}
+	if c.HTTPWriteTimeout < 0 {
+		ps.add("http_write_timeout", "must not be negative, got %s", c.HTTPWriteTimeout)
+	}
+	checkPositive(&ps, "http_idle_timeout", c.HTTPIdleTimeout)
+	if c.HTTPMaxHeaderBytes < 1 {
+		ps.add("http_max_header_bytes", "must be at least 1, got %d", c.HTTPMaxHeaderBytes)
+	}
+
+	// Connection pool settings
+	if c.PoolMaxConns < 1 {
+		ps.add("pool_max_conns", "must be at least 1, got %d", c.PoolMaxConns)
+	}
+	checkPositive(&ps, "pool_idle_timeout", c.PoolIdleTimeout)
+	checkPositive(&ps, "pool_max_lifetime", c.PoolMaxLifetime)
+	checkPositive(&ps, "pool_cleanup_interval", c.PoolCleanupInterval)
+	if c.PoolWaitTimeout < 0 {
+		ps.add("pool_wait_timeout", "must not be negative, got %s", c.PoolWaitTimeout)
+	}
+	if c.PoolMinIdle < 0 || c.PoolMinIdle > c.PoolMaxConns {
+		ps.add("pool_min_idle", "must be between 0 and pool_max_conns (%d), got %d", c.PoolMaxConns, c.PoolMinIdle)
+	}
+
+	// Retry settings
+	if c.RetryMaxAttempts < 1 {
+		ps.add("retry_max_attempts", "must be at least 1, got %d", c.RetryMaxAttempts)
+	}
+	for i, code := range c.RetryOnStatus {
+		if code < 500 || code > 599 {
+			ps.add(fmt.Sprintf("retry_on_status[%d]", i), "must be a 5xx status code, got %d", code)
+		}
+	}
+	if c.RetryBudgetPercent <= 0 || c.RetryBudgetPercent > 100 {
+		ps.add("retry_budget_percent", "must be above 0 and at most 100, got %g", c.RetryBudgetPercent)
+	}
+	if c.RetryBufferBytes < 0 {
+		ps.add("retry_buffer_bytes", "must not be negative, got %d", c.RetryBufferBytes)
+	}
+
+	// Health checker settings
+	checkPositive(&ps, "health_check_interval", c.HealthCheckInterval)
+	checkPositive(&ps, "health_check_timeout", c.HealthCheckTimeout)
+	if c.HealthCheckTimeout >= c.HealthCheckInterval {
+		ps.add("health_check_timeout", "must be shorter than health_check_interval (%s), got %s", c.HealthCheckInterval, c.HealthCheckTimeout)
+	}
+	if c.HealthCheckRise < 1 {
+		ps.add("health_check_rise", "must be at least 1, got %d", c.HealthCheckRise)
+	}
+	if c.HealthCheckFall < 1 {
+		ps.add("health_check_fall", "must be at least 1, got %d", c.HealthCheckFall)
+	}
+	if c.HealthCheckMaxBackoff < 0 {
+		ps.add("health_check_max_backoff", "must not be negative, got %s", c.HealthCheckMaxBackoff)
+	} else if c.HealthCheckMaxBackoff > 0 && c.HealthCheckMaxBackoff < c.HealthCheckInterval {
+		ps.add("health_check_max_backoff", "must be 0 or at least health_check_interval (%s), got %s", c.HealthCheckInterval, c.HealthCheckMaxBackoff)
+	}
+	if c.HealthCheckJitter < 0 || c.HealthCheckJitter >= 1 {
+		ps.add("health_check_jitter", "must be at least 0 and less than 1, got %g", c.HealthCheckJitter)
+	}
+	for _, name := range sortedKeys(c.HealthChecks) {
+		if _, err := health.NewCheck(health.CheckConfig(c.HealthChecks[name])); err != nil {
+			ps.add("health_checks."+name, "%v", err)
+		}
+	}
+
+	// Outlier detection settings
+	if c.OutlierConsecutiveErrors < 1 {
+		ps.add("outlier_consecutive_errors", "must be at least 1, got %d", c.OutlierConsecutiveErrors)
+	}
+	checkPositive(&ps, "outlier_base_ejection_time", c.OutlierBaseEjectionTime)
+	if c.OutlierMaxEjectionTime < c.OutlierBaseEjectionTime {
+		ps.add("outlier_max_ejection_time", "must be at least outlier_base_ejection_time (%s), got %s", c.OutlierBaseEjectionTime, c.OutlierMaxEjectionTime)
+	}
+	if c.OutlierMaxEjectionPercent < 0 || c.OutlierMaxEjectionPercent > 100 {
+		ps.add("outlier_max_ejection_percent", "must be between 0 and 100, got %d", c.OutlierMaxEjectionPercent)
+	}
+
+	// Balancer settings
+	if !contains(balancer.Algorithms(), c.BalancerAlgorithm) {
+		ps.add("balancer_algorithm", "unknown algorithm %q (available: %s)", c.BalancerAlgorithm, strings.Join(balancer.Algorithms(), ", "))
+	}
+	if _, err := balancer.ParseKeyExtractor(c.HashKey); err != nil {
+		ps.add("hash_key", "%v", err)
+	}
+	if c.HashVirtualNodes < 1 {
+		ps.add("hash_virtual_nodes", "must be at least 1, got %d", c.HashVirtualNodes)
+	}
+
+	// Backend-reported load settings
+	if !validHeaderName(c.LoadHeader) {
+		ps.add("load_header", "%q is not a valid header name", c.LoadHeader)
+	}
+	checkPositive(&ps, "load_stale_after", c.LoadStaleAfter)
+
+	// Logging settings
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		ps.add("log_level", "%v", err)
+	}
+	if err := logging.CheckFormat(c.LogFormat); err != nil {
+		ps.add("log_format", "%v", err)
+	}
+
+	// Backend servers
+	seen := make(map[string]int)
+	for i, backend := range c.BackendServers {
+		path := fmt.Sprintf("backend_servers[%d]", i)
+		checkAddress(&ps, path+".address", backend.Address, true)
+		if first, ok := seen[backend.Address]; ok {
+			ps.add(path+".address", "duplicate of backend_servers[%d]", first)
+		} else {
+			seen[backend.Address] = i
+		}
+		if backend.Weight < 0 {
+			ps.add(path+".weight", "must not be negative, got %d", backend.Weight)
+		}
+		if backend.HealthCheck != "" {
+			if _, ok := c.HealthChecks[backend.HealthCheck]; !ok {
+				ps.add(path+".health_check", "unknown health check %q (configured: %s)", backend.HealthCheck, strings.Join(sortedKeys(c.HealthChecks), ", "))
+			}
+		}
+	}
+
+	return ps.err()
+}
+
+// checkAddress reports address if it is not a valid host:port pair. Listen
+// addresses may leave out the host to listen on all interfaces.
+func checkAddress(ps *problems, path, address string, requireHost bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		ps.add(path, "%q is not a host:port address", address)
+		return
+	}
+	if requireHost && host == "" {
+		ps.add(path, "%q is missing a host", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		ps.add(path, "%q has an invalid port", address)
+	}
+}
+
+// checkPositive reports a duration that is zero or negative
+func checkPositive(ps *problems, path string, d Duration) {
+	if d <= 0 {
+		ps.add(path, "must be positive, got %s", d)
+	}
+}
+
+// checkFile reports a file setting that names a file that cannot be read
+func checkFile(ps *problems, path, file string) {
+	if file == "" {
+		return
+	}
+	if _, err := os.Stat(file); err != nil {
+		ps.add(path, "%v", err)
+	}
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 127 || r <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUnknownFields reports keys in the decoded JSON document raw that do
+// not correspond to a field of t, descending into nested objects and arrays
+func checkUnknownFields(ps *problems, raw interface{}, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return // other forms, like a backend written as a string, are checked when decoding
+		}
+		fields := make(map[string]reflect.StructField, t.NumField())
+		names := make([]string, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name := fieldName(t.Field(i))
+			fields[name] = t.Field(i)
+			names = append(names, name)
+		}
+		for _, key := range sortedKeys(object) {
+			field, ok := fields[key]
+			if !ok {
+				message := "unknown field"
+				if suggestion := closest(key, names); suggestion != "" {
+					message += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				ps.add(join(path, key), message)
+				continue
+			}
+			checkUnknownFields(ps, object[key], field.Type, join(path, key))
+		}
+	case reflect.Map:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			checkUnknownFields(ps, object[key], t.Elem(), join(path, key))
+		}
+	case reflect.Slice:
+		array, ok := raw.([]interface{})
+		if !ok {
+			return
+		}
+		for i, element := range array {
+			checkUnknownFields(ps, element, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// within reports whether path is one of parents or a path inside one of them
+func within(path string, parents []string) bool {
+	for _, parent := range parents {
+		if path == parent || strings.HasPrefix(path, parent+".") || strings.HasPrefix(path, parent+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// join appends a key to a JSON path
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closest returns the candidate most similar to name, or "" if none is
+// close enough to be a likely typo
+func closest(name string, candidates []string) string {
+	best, bestDistance := "", len(name)/3+1
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(name), candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// contains reports whether values includes value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
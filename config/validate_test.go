@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes validation
+func validConfig() *Config {
+	c := &Config{}
+	c.setDefaults()
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		problems []string // paths of the expected problems
+	}{
+		{"defaults", func(c *Config) {}, nil},
+		{"same listen and admin address", func(c *Config) { c.AdminAddr = c.ListenAddr }, []string{"admin_addr"}},
+		{"bad backend address", func(c *Config) { c.BackendServers = []BackendServer{{Address: "localhost"}} }, []string{"backend_servers[0].address"}},
+		{
+			"duplicate backend",
+			func(c *Config) {
+				c.BackendServers = []BackendServer{{Address: "localhost:8081"}, {Address: "localhost:8081"}}
+			},
+			[]string{"backend_servers[1].address"},
+		},
+		{"pool minimum above maximum", func(c *Config) { c.PoolMinIdle = c.PoolMaxConns + 1 }, []string{"pool_min_idle"}},
+		{"non-5xx retry status", func(c *Config) { c.RetryOnStatus = []int{503, 404} }, []string{"retry_on_status[1]"}},
+		{
+			"health check timeout not below interval",
+			func(c *Config) { c.HealthCheckTimeout = c.HealthCheckInterval },
+			[]string{"health_check_timeout"},
+		},
+		{
+			"several problems at once",
+			func(c *Config) {
+				c.PoolMaxConns = 0
+				c.PoolMinIdle = 0
+				c.RetryBudgetPercent = 0
+				c.DrainTimeout = Duration(-time.Second)
+			},
+			[]string{"drain_timeout", "pool_max_conns", "retry_budget_percent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.change(c)
+
+			var got []string
+			if err := c.Validate(); err != nil {
+				for _, p := range err.(*ValidationError).Problems {
+					got = append(got, p.Path)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.problems) {
+				t.Errorf("problems at %v, want %v (%v)", got, tt.problems, c.Validate())
+			}
+		})
+	}
+}
+
+func TestCheckUnknownFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		problems []Problem
+	}{
+		{"known fields", `{"listen_addr": ":8080", "backend_servers": [{"address": "a:1"}]}`, nil},
+		{"misspelled field", `{"listen_adr": ":8080"}`, []Problem{{"listen_adr", `unknown field, did you mean "listen_addr"?`}}},
+		{"unrelated field", `{"colour": "blue"}`, []Problem{{"colour", "unknown field"}}},
+		{
+			"inside a list",
+			`{"backend_servers": ["a:1", {"address": "b:1", "wieght": 2}]}`,
+			[]Problem{{"backend_servers[1].wieght", `unknown field, did you mean "weight"?`}},
+		},
+		{
+			"inside a map",
+			`{"health_checks": {"default": {"path": "/", "methd": "GET"}}}`,
+			[]Problem{{"health_checks.default.methd", `unknown field, did you mean "method"?`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			if err := json.Unmarshal([]byte(tt.document), &raw); err != nil {
+				t.Fatal(err)
+			}
+			var ps problems
+			checkUnknownFields(&ps, raw, reflect.TypeOf(&Config{}), "")
+			if !reflect.DeepEqual([]Problem(ps), tt.problems) {
+				t.Errorf("problems = %v, want %v", ps, tt.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"simple_load_balancer/internal/balancer"
+	"simple_load_balancer/internal/health"
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/pool"
+	"simple_load_balancer/internal/registry"
+	"simple_load_balancer/internal/upgrade"
+)
+
+// Server serves the load balancer's administrative API on its own
+// listener, separate from the proxied traffic
+type Server struct {
+	address      string
+	token        string
+	drainTimeout time.Duration
+	registry     *registry.Registry
+	balancer     *balancer.Balancer
+	health       *health.HealthChecker
+	pool         *pool.Pool
+	router       *chi.Mux
+	server       *http.Server
+	listener     net.Listener
+}
+
+// Config holds the configuration for the admin Server
+type Config struct {
+	Address string
+	// Token must be sent as a bearer token with every request. Without a
+	// token only read-only requests are served.
+	Token string
+	// DrainTimeout bounds how long a backend being removed may take to
+	// finish its in-flight requests
+	DrainTimeout time.Duration
+}
+
+// New creates and initializes a new admin Server
+func New(cfg Config, reg *registry.Registry, bal *balancer.Balancer, healthChecker *health.HealthChecker, connPool *pool.Pool) *Server {
+	s := &Server{
+		address:      cfg.Address,
+		token:        cfg.Token,
+		drainTimeout: cfg.DrainTimeout,
+		registry:     reg,
+		balancer:     bal,
+		health:       healthChecker,
+		pool:         connPool,
+		router:       chi.NewRouter(),
+	}
+	s.setupRoutes()
+	s.server = &http.Server{Addr: s.address, Handler: s.router}
+	return s
+}
+
+// Listen opens the admin listener, or takes over the one inherited from
+// the process that started this one during a binary upgrade
+func (s *Server) Listen() error {
+	listener, err := upgrade.Listen("admin", s.address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	logging.Infof("Admin API listening on %s", s.address)
+	return nil
+}
+
+// Serve serves the admin API on the listener opened by Listen and blocks
+// until it stops
+func (s *Server) Serve() error {
+	if err := s.server.Serve(s.listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Socket returns the admin listener so it can be passed to a new process
+// during a binary upgrade
+func (s *Server) Socket() net.Listener {
+	return s.listener
+}
+
+// Shutdown stops the admin API, waiting for active requests to finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) setupRoutes() {
+	s.router.Use(s.authenticate)
+
+	s.router.Get("/health/backends", s.listHealth)
+	s.router.Get("/health/backends/{address}", s.getHealth)
+
+	s.router.Get("/metrics", s.metrics)
+
+	s.router.Get("/backends", s.listBackends)
+	s.router.Post("/backends", s.addBackend)
+	s.router.Get("/backends/{address}", s.getBackend)
+	s.router.Delete("/backends/{address}", s.removeBackend)
+	s.router.Post("/backends/{address}/drain", s.drainBackend)
+	s.router.Post("/backends/{address}/enable", s.enableBackend)
+	s.router.Post("/backends/{address}/disable", s.disableBackend)
+	s.router.Put("/backends/{address}/weight", s.setBackendWeight)
+}
+
+// authenticate requires the configured bearer token on every request. When
+// no token is configured, requests that change state are refused outright.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "Admin token not configured", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listHealth returns the health status and recent transitions of every backend
+func (s *Server) listHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.health.Status())
+}
+
+// getHealth returns the health status and recent transitions of one backend
+func (s *Server) getHealth(w http.ResponseWriter, r *http.Request) {
+	address := chi.URLParam(r, "address")
+	status, ok := s.health.BackendStatus(address)
+	if !ok {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// metrics reports the load balancer's runtime statistics
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pool": s.pool.Stats(),
+	})
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Errorf("Error encoding admin response: %v", err)
+	}
+}
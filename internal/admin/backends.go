@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"simple_load_balancer/internal/balancer"
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/registry"
+)
+
+// backendView is the admin API representation of a backend
+type backendView struct {
+	Address        string `json:"address"`
+	Weight         int    `json:"weight"`
+	HealthCheck    string `json:"health_check,omitempty"`
+	Health         string `json:"health"`
+	Disabled       bool   `json:"disabled"`
+	Draining       bool   `json:"draining"`
+	ActiveRequests int64  `json:"active_requests"`
+	// Drain is set while the backend is draining, so deploy scripts can
+	// poll the remaining in-flight count
+	Drain *balancer.DrainStatus `json:"drain,omitempty"`
+}
+
+func (s *Server) view(backend registry.Backend) backendView {
+	view := backendView{
+		Address:        backend.Address,
+		Weight:         backend.EffectiveWeight(),
+		HealthCheck:    backend.HealthCheck,
+		Health:         backend.Health.String(),
+		Disabled:       backend.Disabled,
+		Draining:       backend.Draining,
+		ActiveRequests: s.balancer.ActiveRequests(backend.Address),
+	}
+	if drain, ok := s.balancer.DrainStatus(backend.Address); ok {
+		view.Drain = &drain
+	}
+	return view
+}
+
+// listBackends returns every registered backend
+func (s *Server) listBackends(w http.ResponseWriter, r *http.Request) {
+	backends := s.registry.GetAll()
+	views := make([]backendView, 0, len(backends))
+	for _, backend := range backends {
+		views = append(views, s.view(backend))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// getBackend returns a single backend
+func (s *Server) getBackend(w http.ResponseWriter, r *http.Request) {
+	backend, ok := s.registry.Get(chi.URLParam(r, "address"))
+	if !ok {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.view(backend))
+}
+
+// addBackend registers a new backend, or one that is being drained again
+func (s *Server) addBackend(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Address     string `json:"address"`
+		Weight      int    `json:"weight"`
+		HealthCheck string `json:"health_check"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(req.Address); err != nil {
+		http.Error(w, "Invalid backend address: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Weight < 0 {
+		http.Error(w, "Weight must not be negative", http.StatusBadRequest)
+		return
+	}
+	// A draining backend can be added again, which stops the drain so it is
+	// not removed once the drain finishes
+	if existing, exists := s.registry.Get(req.Address); exists {
+		if !existing.Draining {
+			http.Error(w, "Backend already registered", http.StatusConflict)
+			return
+		}
+		s.balancer.CancelDrain(req.Address)
+	}
+
+	backend := registry.Backend{Address: req.Address, Weight: req.Weight, HealthCheck: req.HealthCheck}
+	s.registry.Add(backend)
+	logging.Infof("Admin API: added backend %s", backend.Address)
+	writeJSON(w, http.StatusCreated, s.view(backend))
+}
+
+// removeBackend drains a backend and removes it once its in-flight requests
+// have finished. With ?force=true it is removed immediately instead.
+func (s *Server) removeBackend(w http.ResponseWriter, r *http.Request) {
+	address := chi.URLParam(r, "address")
+	if r.URL.Query().Get("force") != "true" {
+		s.drainBackend(w, r)
+		return
+	}
+
+	s.balancer.CancelDrain(address)
+	if !s.registry.Remove(address) {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+	logging.Infof("Admin API: removed backend %s", address)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// drainBackend stops routing new requests to a backend and removes it once
+// its in-flight requests have finished or the drain timeout has passed
+func (s *Server) drainBackend(w http.ResponseWriter, r *http.Request) {
+	address := chi.URLParam(r, "address")
+	if !s.balancer.Drain(address, s.drainTimeout) {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+	logging.Infof("Admin API: draining backend %s", address)
+
+	backend, _ := s.registry.Get(address)
+	writeJSON(w, http.StatusAccepted, s.view(backend))
+}
+
+// enableBackend puts a disabled or draining backend back into rotation
+func (s *Server) enableBackend(w http.ResponseWriter, r *http.Request) {
+	s.updateBackend(w, r, "enabled", func(address string) bool {
+		s.balancer.CancelDrain(address)
+		return s.registry.SetDisabled(address, false)
+	})
+}
+
+// disableBackend takes a backend out of rotation until it is enabled again
+func (s *Server) disableBackend(w http.ResponseWriter, r *http.Request) {
+	s.updateBackend(w, r, "disabled", func(address string) bool {
+		return s.registry.SetDisabled(address, true)
+	})
+}
+
+// setBackendWeight changes the weight of a backend
+func (s *Server) setBackendWeight(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Weight int `json:"weight"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Weight < 1 {
+		http.Error(w, "Weight must be at least 1", http.StatusBadRequest)
+		return
+	}
+	s.updateBackend(w, r, "reweighted", func(address string) bool {
+		return s.registry.SetWeight(address, req.Weight)
+	})
+}
+
+// updateBackend applies a change to the backend named in the URL and
+// responds with its new state
+func (s *Server) updateBackend(w http.ResponseWriter, r *http.Request, action string, apply func(address string) bool) {
+	address := chi.URLParam(r, "address")
+	if !apply(address) {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+	logging.Infof("Admin API: %s backend %s", action, address)
+
+	backend, _ := s.registry.Get(address)
+	writeJSON(w, http.StatusOK, s.view(backend))
+}
@@ -1,93 +1,306 @@
 package balancer
 
 import (
+	"math"
+	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/outlier"
 	"simple_load_balancer/internal/registry"
 )
 
-// Balancer implements a load-aware balancing algorithm
+// Balancer picks backends using the configured balancing strategy
 type Balancer struct {
 	registry *registry.Registry
+	settings atomic.Pointer[settings]
+	outliers *outlier.Detector
 	mu       sync.RWMutex
-	serverLoads map[string]float64
-	lastUpdate time.Time
+	stats    map[string]*backendStats
+	drains   map[string]*drain
+}
+
+// settings holds the parts of the Balancer that can be changed by
+// Reconfigure. They are replaced as a whole so a request never sees a
+// strategy paired with another configuration's hash key.
+type settings struct {
+	algorithm    string
+	strategy     Strategy
+	hashKey      KeyExtractor
+	virtualNodes int
+	loadStale    time.Duration
+}
+
+// Config holds the configuration for the Balancer
+type Config struct {
+	// Algorithm is the name of a registered Strategy
+	Algorithm string
+	// HashKey selects the request attribute used by hashing strategies,
+	// see ParseKeyExtractor for the accepted forms
+	HashKey string
+	// VirtualNodes is the number of ring points per unit of backend weight
+	VirtualNodes int
+	// LoadStaleAfter is how long a load reported by a backend is trusted;
+	// older reports are ignored as if the backend had never reported
+	LoadStaleAfter time.Duration
+}
+
+// neutralLoad is assumed for backends without a fresh load report
+const neutralLoad = 50
+
+// latencyDecay is the time constant of the latency EWMA. A sample this old
+// carries about 37% of its original weight.
+const latencyDecay = 10 * time.Second
+
+// backendStats holds the live counters the balancer keeps for one backend
+type backendStats struct {
+	active atomic.Int64
+
+	mu        sync.Mutex
+	latency   float64 // peak-EWMA of observed latency, in nanoseconds
+	latencyAt time.Time
+	load      float64 // utilization percentage last reported by the backend
+	loadAt    time.Time
+}
+
+// observe folds a latency sample into the peak-EWMA. A sample above the
+// current average replaces it outright so a backend that slows down is
+// penalised immediately, while improvements are only trusted gradually.
+func (s *backendStats) observe(sample time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value := float64(sample)
+	if s.latencyAt.IsZero() || value > s.latency {
+		s.latency = value
+	} else {
+		w := math.Exp(-float64(now.Sub(s.latencyAt)) / float64(latencyDecay))
+		s.latency = s.latency*w + value*(1-w)
+	}
+	s.latencyAt = now
+}
+
+// averageLatency returns the current peak-EWMA and whether any sample has been observed
+func (s *backendStats) averageLatency() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Duration(s.latency), !s.latencyAt.IsZero()
 }
 
-// New creates and initializes a new Balancer
-func New(registry *registry.Registry) *Balancer {
+// reportLoad records a utilization percentage reported by the backend
+func (s *backendStats) reportLoad(load float64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.load = load
+	s.loadAt = now
+}
+
+// reportedLoad returns the last reported load and when it was reported
+func (s *backendStats) reportedLoad() (float64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load, s.loadAt
+}
+
+// ServerLoad is a snapshot of what the balancer knows about one backend
+type ServerLoad struct {
+	Load           float64       `json:"load"`
+	LoadReportedAt time.Time     `json:"load_reported_at"`
+	ActiveRequests int64         `json:"active_requests"`
+	Latency        time.Duration `json:"latency"`
+}
+
+// New creates and initializes a new Balancer using the configured algorithm
+func New(registry *registry.Registry, cfg Config) (*Balancer, error) {
 	b := &Balancer{
 		registry: registry,
-		serverLoads: make(map[string]float64),
-		lastUpdate: time.Now(),
+		stats:    make(map[string]*backendStats),
+		drains:   make(map[string]*drain),
+	}
+	if err := b.Reconfigure(cfg); err != nil {
+		return nil, err
 	}
-	go b.periodicLoadUpdate()
-	return b
+	return b, nil
 }
 
-// NextBackend selects the next backend server based on current load
-func (b *Balancer) NextBackend() *registry.Backend {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+// Reconfigure switches the balancer to a new configuration while it is
+// serving traffic. If cfg is invalid the current configuration is kept.
+// Counters such as in-flight requests and latency are preserved, but the
+// strategy is rebuilt, so hashing strategies remap keys if the hash key or
+// virtual node count changed.
+func (b *Balancer) Reconfigure(cfg Config) error {
+	hashKey, err := ParseKeyExtractor(cfg.HashKey)
+	if err != nil {
+		return err
+	}
+	strategy, err := newStrategy(cfg.Algorithm, b)
+	if err != nil {
+		return err
+	}
+	b.settings.Store(&settings{
+		algorithm:    cfg.Algorithm,
+		strategy:     strategy,
+		hashKey:      hashKey,
+		virtualNodes: cfg.VirtualNodes,
+		loadStale:    cfg.LoadStaleAfter,
+	})
+	return nil
+}
+
+// Algorithm returns the name of the strategy in use
+func (b *Balancer) Algorithm() string {
+	return b.settings.Load().algorithm
+}
 
-	backends := b.registry.GetAll()
+// SetOutlierDetector makes the balancer skip backends the detector has ejected
+func (b *Balancer) SetOutlierDetector(detector *outlier.Detector) {
+	b.outliers = detector
+}
+
+// NextBackend selects the backend server that should handle r using the
+// configured strategy. Backends that are disabled, draining, marked
+// unhealthy or ejected by the outlier detector are never selected.
+func (b *Balancer) NextBackend(r *http.Request) *registry.Backend {
+	backends := b.available(b.registry.GetAll())
 	if len(backends) == 0 {
 		return nil
 	}
 
-	var leastLoadedBackend *registry.Backend
-	minLoad := float64(101) // Initialize with a value higher than possible load percentage
+	return b.settings.Load().strategy.Next(backends, r)
+}
 
-	for _, backend := range backends {
-		load, exists := b.serverLoads[backend.Address]
-		if !exists {
-			// If we don't have load info, assume 50% as a neutral value
-			load = 50
+// RetryBackend selects the backend for retrying a request that has failed
+// on the tried backends, the last one being the most recent. It walks the
+// registry from the last tried backend to the next available one not yet
+// tried, bypassing the strategy so that retries neither advance its
+// counters nor make hashing strategies rebuild their tables for a
+// different set of backends.
+func (b *Balancer) RetryBackend(tried []string) *registry.Backend {
+	all := b.registry.GetAll()
+	start := 0
+	if len(tried) > 0 {
+		start = slices.IndexFunc(all, func(backend registry.Backend) bool {
+			return backend.Address == tried[len(tried)-1]
+		}) + 1
+	}
+
+	backends := b.available(append(all[start:len(all):len(all)], all[:start]...))
+	for i := range backends {
+		if !slices.Contains(tried, backends[i].Address) {
+			return &backends[i]
 		}
+	}
+	return nil
+}
 
-		if load < minLoad {
-			minLoad = load
-			leastLoadedBackend = &backend
+// available filters backends down to those that may receive traffic.
+// Backends that have not been checked yet are given the benefit of the doubt.
+func (b *Balancer) available(backends []registry.Backend) []registry.Backend {
+	filtered := backends[:0]
+	for _, backend := range backends {
+		if backend.Disabled || backend.Draining || backend.Health == registry.HealthUnhealthy {
+			continue
+		}
+		if b.outliers != nil && b.outliers.IsEjected(backend.Address) {
+			continue
 		}
+		filtered = append(filtered, backend)
 	}
+	return filtered
+}
 
-	return leastLoadedBackend
+// Acquire records that a request has been dispatched to a backend.
+// Every call must be paired with a call to Release once the request is done.
+func (b *Balancer) Acquire(serverAddress string) {
+	b.statsFor(serverAddress).active.Add(1)
 }
 
-// UpdateServerLoad updates the load information for a specific server
-func (b *Balancer) UpdateServerLoad(serverAddress string, load float64) {
+// Release records that a request dispatched to a backend has finished
+func (b *Balancer) Release(serverAddress string) {
+	b.statsFor(serverAddress).active.Add(-1)
+}
+
+// ActiveRequests returns the number of in-flight requests on a backend
+func (b *Balancer) ActiveRequests(serverAddress string) int64 {
+	b.mu.RLock()
+	stats, exists := b.stats[serverAddress]
+	b.mu.RUnlock()
+
+	if !exists {
+		return 0
+	}
+	return stats.active.Load()
+}
+
+// ObserveLatency records how long a backend took to respond
+func (b *Balancer) ObserveLatency(serverAddress string, latency time.Duration) {
+	b.statsFor(serverAddress).observe(latency, time.Now())
+}
+
+// statsFor returns the counters for a backend, creating them on first use
+func (b *Balancer) statsFor(serverAddress string) *backendStats {
+	b.mu.RLock()
+	stats, exists := b.stats[serverAddress]
+	b.mu.RUnlock()
+	if exists {
+		return stats
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
-
-	b.serverLoads[serverAddress] = load
-	b.lastUpdate = time.Now()
+	if stats, exists = b.stats[serverAddress]; !exists {
+		stats = &backendStats{}
+		b.stats[serverAddress] = stats
+	}
+	return stats
 }
 
-// periodicLoadUpdate simulates periodic load updates from servers
-// In a real-world scenario, this would be replaced by actual server metrics
-func (b *Balancer) periodicLoadUpdate() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+// serverLoad returns the last load a server reported, or a neutral value
+// if it has not reported within the staleness window
+func (b *Balancer) serverLoad(serverAddress string) float64 {
+	b.mu.RLock()
+	stats, exists := b.stats[serverAddress]
+	b.mu.RUnlock()
+	if !exists {
+		return neutralLoad
+	}
 
-	for range ticker.C {
-		b.mu.Lock()
-		for _, backend := range b.registry.GetAll() {
-			// Simulate load update. In reality, you'd get this data from the server
-			b.serverLoads[backend.Address] = float64(50 + (time.Now().UnixNano() % 50))
-		}
-		b.lastUpdate = time.Now()
-		b.mu.Unlock()
+	load, reportedAt := stats.reportedLoad()
+	loadStale := b.settings.Load().loadStale
+	if reportedAt.IsZero() || (loadStale > 0 && time.Since(reportedAt) > loadStale) {
+		return neutralLoad
+	}
+	return load
+}
+
+// UpdateServerLoad records a utilization percentage reported by a server,
+// either through a response header or its health endpoint. Loads outside
+// 0-100 are clamped into it, and values that are not numbers are ignored.
+func (b *Balancer) UpdateServerLoad(serverAddress string, load float64) {
+	if math.IsNaN(load) || math.IsInf(load, 0) {
+		logging.Warnf("Ignoring invalid load %v reported by backend %s", load, serverAddress)
+		return
 	}
+	b.statsFor(serverAddress).reportLoad(min(max(load, 0), 100), time.Now())
 }
 
-// GetServerLoads returns the current load information for all servers
-func (b *Balancer) GetServerLoads() map[string]float64 {
+// GetServerLoads returns the current load and in-flight request count for all servers
+func (b *Balancer) GetServerLoads() map[string]ServerLoad {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	loads := make(map[string]float64)
-	for k, v := range b.serverLoads {
-		loads[k] = v
+	loads := make(map[string]ServerLoad)
+	for k, stats := range b.stats {
+		load := ServerLoad{ActiveRequests: stats.active.Load()}
+		load.Load, load.LoadReportedAt = stats.reportedLoad()
+		load.Latency, _ = stats.averageLatency()
+		loads[k] = load
 	}
 	return loads
-}
\ No newline at end of file
+}
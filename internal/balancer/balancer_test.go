@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"simple_load_balancer/internal/registry"
+)
+
+// newTestBalancer returns a balancer over an empty registry. Strategies
+// built on it hash requests on their X-Key header.
+func newTestBalancer(t *testing.T) *Balancer {
+	t.Helper()
+	b, err := New(registry.New(filepath.Join(t.TempDir(), "registry.json")), Config{
+		Algorithm: "round_robin",
+		HashKey:   "header:X-Key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testBackends returns n backends with distinct addresses
+func testBackends(n int) []registry.Backend {
+	backends := make([]registry.Backend, n)
+	for i := range backends {
+		backends[i] = registry.Backend{Address: fmt.Sprintf("10.0.0.%d:8080", i+1)}
+	}
+	return backends
+}
+
+func TestRetryBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled string
+		tried    []string
+		expected string // empty when no backend is left
+	}{
+		{"next after the first", "", []string{"a"}, "b"},
+		{"next after the last wraps around", "", []string{"c"}, "a"},
+		{"skips backends already tried", "", []string{"b", "a"}, "c"},
+		{"skips backends out of rotation", "b", []string{"a"}, "c"},
+		{"none left", "", []string{"a", "b", "c"}, ""},
+		{"none left in rotation", "c", []string{"a", "b"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := registry.New(filepath.Join(t.TempDir(), "registry.json"))
+			for _, address := range []string{"a", "b", "c"} {
+				reg.Add(registry.Backend{Address: address, Disabled: address == tt.disabled})
+			}
+			b, err := New(reg, Config{Algorithm: "round_robin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := b.RetryBackend(tt.tried)
+			switch {
+			case tt.expected == "" && got != nil:
+				t.Errorf("RetryBackend(%v) = %s, want none", tt.tried, got.Address)
+			case tt.expected != "" && (got == nil || got.Address != tt.expected):
+				t.Errorf("RetryBackend(%v) = %v, want %s", tt.tried, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package balancer
+
+import (
+	"time"
+
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/registry"
+)
+
+// drainPollInterval is how often a draining backend's in-flight count is checked
+const drainPollInterval = 100 * time.Millisecond
+
+// drain tracks a backend that is being drained
+type drain struct {
+	startedAt time.Time
+	deadline  time.Time
+	cancel    chan struct{}
+}
+
+// DrainStatus reports the progress of draining a backend
+type DrainStatus struct {
+	StartedAt time.Time `json:"started_at"`
+	Deadline  time.Time `json:"deadline"`
+	InFlight  int64     `json:"in_flight"`
+}
+
+// Drain stops routing new requests to a backend, waits for its in-flight
+// requests to finish, or for timeout to pass, and then removes it from the
+// registry. It returns false if the backend is not registered. Draining a
+// backend that is already draining leaves the original deadline in place.
+func (b *Balancer) Drain(address string, timeout time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, draining := b.drains[address]; draining {
+		return true
+	}
+
+	now := time.Now()
+	if !b.registry.SetDraining(address, &registry.Drain{StartedAt: now, Deadline: now.Add(timeout)}) {
+		return false
+	}
+	logging.Infof("Draining backend %s (timeout %v)", address, timeout)
+	b.startDrain(address, now, now.Add(timeout))
+	return true
+}
+
+// ResumeDrains picks up the drains recorded in the registry file, so a
+// restart or upgrade does not leave backends draining forever
+func (b *Balancer) ResumeDrains() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, backend := range b.registry.GetAll() {
+		if backend.Drain == nil {
+			continue
+		}
+		if _, draining := b.drains[backend.Address]; draining {
+			continue
+		}
+		logging.Infof("Resuming drain of backend %s (deadline %v)", backend.Address, backend.Drain.Deadline.Format(time.RFC3339))
+		b.startDrain(backend.Address, backend.Drain.StartedAt, backend.Drain.Deadline)
+	}
+}
+
+// startDrain tracks a drain and waits for it in the background. The caller
+// must hold b.mu.
+func (b *Balancer) startDrain(address string, startedAt, deadline time.Time) {
+	d := &drain{
+		startedAt: startedAt,
+		deadline:  deadline,
+		cancel:    make(chan struct{}),
+	}
+	b.drains[address] = d
+	go b.waitForDrain(address, d)
+}
+
+// CancelDrain stops draining a backend and puts it back into rotation. It
+// returns false if the backend was not being drained.
+func (b *Balancer) CancelDrain(address string) bool {
+	b.mu.Lock()
+	d, draining := b.drains[address]
+	if draining {
+		delete(b.drains, address)
+		close(d.cancel)
+	}
+	b.mu.Unlock()
+
+	b.registry.SetDraining(address, nil)
+	if draining {
+		logging.Infof("Cancelled draining of backend %s", address)
+	}
+	return draining
+}
+
+// DrainStatus returns the progress of draining a backend, if it is being drained
+func (b *Balancer) DrainStatus(address string) (DrainStatus, bool) {
+	b.mu.RLock()
+	d, draining := b.drains[address]
+	b.mu.RUnlock()
+
+	if !draining {
+		return DrainStatus{}, false
+	}
+	return DrainStatus{
+		StartedAt: d.startedAt,
+		Deadline:  d.deadline,
+		InFlight:  b.ActiveRequests(address),
+	}, true
+}
+
+// waitForDrain removes a draining backend once it is idle or its deadline passes
+func (b *Balancer) waitForDrain(address string, d *drain) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.cancel:
+			return
+		case now := <-ticker.C:
+			inFlight := b.ActiveRequests(address)
+			if inFlight > 0 && now.Before(d.deadline) {
+				continue
+			}
+
+			b.mu.Lock()
+			if b.drains[address] != d {
+				b.mu.Unlock()
+				return // cancelled while we were checking
+			}
+			delete(b.drains, address)
+			b.mu.Unlock()
+
+			if inFlight > 0 {
+				logging.Warnf("Drain timeout for backend %s, removing it with %d requests in flight", address, inFlight)
+			} else {
+				logging.Infof("Backend %s drained", address)
+			}
+			b.registry.Remove(address)
+			return
+		}
+	}
+}
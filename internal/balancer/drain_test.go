@@ -0,0 +1,39 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func TestResumeDrains(t *testing.T) {
+	b := newTestBalancer(t)
+	now := time.Now()
+	b.registry.Add(registry.Backend{Address: "expired:1"})
+	b.registry.Add(registry.Backend{Address: "pending:1"})
+	b.registry.Add(registry.Backend{Address: "serving:1"})
+	b.registry.SetDraining("expired:1", &registry.Drain{StartedAt: now.Add(-time.Hour), Deadline: now.Add(-time.Minute)})
+	b.registry.SetDraining("pending:1", &registry.Drain{StartedAt: now, Deadline: now.Add(time.Hour)})
+	b.Acquire("pending:1")
+	defer b.Release("pending:1")
+	defer b.CancelDrain("pending:1") // stop it before the registry file goes away
+
+	b.ResumeDrains()
+
+	status, ok := b.DrainStatus("pending:1")
+	if !ok || !status.Deadline.Equal(now.Add(time.Hour)) {
+		t.Errorf("drain status = %+v, %v; want the recorded deadline", status, ok)
+	}
+	if _, ok := b.DrainStatus("serving:1"); ok {
+		t.Error("backend that was not draining started draining")
+	}
+
+	time.Sleep(3 * drainPollInterval)
+	if _, ok := b.registry.Get("expired:1"); ok {
+		t.Error("backend past its drain deadline was not removed")
+	}
+	if _, ok := b.registry.Get("pending:1"); !ok {
+		t.Error("backend with requests in flight was removed before its deadline")
+	}
+}
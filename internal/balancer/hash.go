@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"hash/fnv"
+
+	"simple_load_balancer/internal/registry"
+)
+
+// hashString hashes s to 64 bits. FNV-1a is fast but distributes short,
+// similar strings poorly, so the result is passed through a splitmix64
+// finalizer to spread it across the whole range.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// member identifies a backend for the purpose of building a hash table
+type member struct {
+	address string
+	weight  int
+}
+
+// membership records the backend set a hash table was built from, so hashing
+// strategies can tell when the registry has changed and the table needs rebuilding
+type membership []member
+
+func newMembership(backends []registry.Backend) membership {
+	m := make(membership, len(backends))
+	for i, backend := range backends {
+		m[i] = member{address: backend.Address, weight: backend.EffectiveWeight()}
+	}
+	return m
+}
+
+// matches reports whether backends is the same set, in the same order, that m was built from
+func (m membership) matches(backends []registry.Backend) bool {
+	if len(m) != len(backends) {
+		return false
+	}
+	for i, backend := range backends {
+		if m[i].address != backend.Address || m[i].weight != backend.EffectiveWeight() {
+			return false
+		}
+	}
+	return true
+}
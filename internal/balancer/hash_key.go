@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// KeyExtractor derives the routing key for a request
+type KeyExtractor func(r *http.Request) string
+
+// ParseKeyExtractor builds a KeyExtractor from a hash key spec. Supported forms:
+//
+//	ip                 the client IP address (the default)
+//	header:<name>      the value of a request header
+//	cookie:<name>      the value of a cookie
+//	path               the full request path
+//	path_prefix:<n>    the first n segments of the request path
+//
+// Requests that do not carry the configured attribute fall back to the client IP.
+func ParseKeyExtractor(spec string) (KeyExtractor, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+
+	var extract KeyExtractor
+	switch kind {
+	case "", "ip":
+		return clientIP, nil
+	case "header":
+		if arg == "" {
+			return nil, fmt.Errorf("hash key %q: missing header name", spec)
+		}
+		name := http.CanonicalHeaderKey(arg)
+		extract = func(r *http.Request) string {
+			return r.Header.Get(name)
+		}
+	case "cookie":
+		if arg == "" {
+			return nil, fmt.Errorf("hash key %q: missing cookie name", spec)
+		}
+		extract = func(r *http.Request) string {
+			cookie, err := r.Cookie(arg)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		}
+	case "path":
+		extract = func(r *http.Request) string {
+			return r.URL.Path
+		}
+	case "path_prefix":
+		segments, err := strconv.Atoi(arg)
+		if err != nil || segments < 1 {
+			return nil, fmt.Errorf("hash key %q: path_prefix needs a positive segment count", spec)
+		}
+		extract = func(r *http.Request) string {
+			return pathPrefix(r.URL.Path, segments)
+		}
+	default:
+		return nil, fmt.Errorf("unknown hash key %q", spec)
+	}
+
+	return func(r *http.Request) string {
+		if key := extract(r); key != "" {
+			return key
+		}
+		return clientIP(r)
+	}, nil
+}
+
+// clientIP returns the IP address of the client that sent r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// pathPrefix returns the first n segments of path, e.g. "/a/b" for ("/a/b/c", 2)
+func pathPrefix(path string, n int) string {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", n+1)
+	if len(segments) > n {
+		segments = segments[:n]
+	}
+	return "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,71 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"simple_load_balancer/internal/registry"
+)
+
+// numKeys is how many routing keys the hashing tests send
+const numKeys = 10000
+
+// route returns the backend s picks for every test key
+func route(s Strategy, backends []registry.Backend) []string {
+	routes := make([]string, numKeys)
+	for i := range routes {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Key", fmt.Sprintf("key-%d", i))
+		routes[i] = s.Next(backends, r).Address
+	}
+	return routes
+}
+
+// testKeyMovement checks that a consistent hashing strategy only moves about
+// 1/N of the keys when a backend joins or leaves, and almost only those it
+// must: keys move to a new backend, or away from a removed one. stray is the
+// fraction of keys that may move between backends that did not change.
+func testKeyMovement(t *testing.T, newStrategy func(*Balancer) Strategy, stray float64) {
+	tests := []struct {
+		name   string
+		before int
+		after  int
+	}{
+		{"add to 4", 4, 5},
+		{"add to 9", 9, 10},
+		{"remove from 5", 5, 4},
+		{"remove from 10", 10, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStrategy(newTestBalancer(t))
+			before := route(s, testBackends(tt.before))
+			after := route(s, testBackends(tt.after))
+
+			// testBackends(n) is a prefix of testBackends(n+1), so the
+			// changed backend is always the last one of the larger set
+			changed := testBackends(max(tt.before, tt.after))[max(tt.before, tt.after)-1].Address
+			moved, strayed := 0, 0
+			for i := range before {
+				if before[i] == after[i] {
+					continue
+				}
+				moved++
+				if before[i] != changed && after[i] != changed {
+					strayed++
+				}
+			}
+			if limit := int(stray * numKeys); strayed > limit {
+				t.Errorf("%d keys moved between backends that did not change, want at most %d", strayed, limit)
+			}
+
+			// The changed backend owned about 1/N of the keys
+			want := float64(numKeys) / float64(max(tt.before, tt.after))
+			if got := float64(moved); got < want*0.5 || got > want*1.5 {
+				t.Errorf("%d of %d keys moved, want about %.0f", moved, numKeys, want)
+			}
+		})
+	}
+}
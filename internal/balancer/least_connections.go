@@ -0,0 +1,38 @@
+package balancer
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("least_connections", func(b *Balancer) Strategy { return &leastConnections{balancer: b} })
+}
+
+// leastConnections picks the backend with the fewest in-flight requests.
+// The scan starts at a rotating offset so ties are broken round-robin
+// instead of always favouring the first backend in the registry.
+type leastConnections struct {
+	balancer *Balancer
+	counter  atomic.Uint64
+}
+
+func (s *leastConnections) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	n := len(backends)
+	start := int((s.counter.Add(1) - 1) % uint64(n))
+
+	best := &backends[start]
+	minActive := s.balancer.ActiveRequests(best.Address)
+
+	for i := 1; i < n; i++ {
+		candidate := &backends[(start+i)%n]
+		if active := s.balancer.ActiveRequests(candidate.Address); active < minActive {
+			minActive = active
+			best = candidate
+		}
+	}
+
+	return best
+}
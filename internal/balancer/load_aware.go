@@ -0,0 +1,32 @@
+package balancer
+
+import (
+	"math"
+	"net/http"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("load_aware", func(b *Balancer) Strategy { return &loadAware{balancer: b} })
+}
+
+// loadAware picks the backend reporting the lowest load percentage
+type loadAware struct {
+	balancer *Balancer
+}
+
+func (s *loadAware) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	var leastLoadedBackend *registry.Backend
+	minLoad := math.Inf(1)
+
+	for i := range backends {
+		load := s.balancer.serverLoad(backends[i].Address)
+		if load < minLoad {
+			minLoad = load
+			leastLoadedBackend = &backends[i]
+		}
+	}
+
+	return leastLoadedBackend
+}
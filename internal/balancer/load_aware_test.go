@@ -0,0 +1,40 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLoadAwarePicksLeastLoaded(t *testing.T) {
+	tests := []struct {
+		name     string
+		loads    []float64
+		expected int // index of the backend that should be picked
+	}{
+		{"lowest load", []float64{70, 20, 45}, 1},
+		{"loads above 100 are clamped", []float64{150, 120}, 0},
+		{"negative loads are clamped", []float64{30, -10}, 1},
+		// A backend whose report is ignored counts as neutrally loaded
+		{"invalid loads are ignored", []float64{math.NaN(), 60}, 0},
+		{"every load invalid or too high", []float64{150, math.NaN(), math.Inf(1)}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBalancer(t)
+			backends := testBackends(len(tt.loads))
+			for i, load := range tt.loads {
+				b.UpdateServerLoad(backends[i].Address, load)
+			}
+
+			s := &loadAware{balancer: b}
+			got := s.Next(backends, nil)
+			if got == nil {
+				t.Fatal("no backend picked")
+			}
+			if got.Address != backends[tt.expected].Address {
+				t.Errorf("picked %s, want %s", got.Address, backends[tt.expected].Address)
+			}
+		})
+	}
+}
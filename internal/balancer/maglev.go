@@ -0,0 +1,91 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("maglev", func(b *Balancer) Strategy {
+		return &maglev{balancer: b}
+	})
+}
+
+// maglevTableSize is the number of lookup table entries. It must be a prime
+// much larger than the number of backends for an even spread.
+const maglevTableSize = 65537
+
+// maglev implements Google's Maglev consistent hashing. Every backend fills
+// the lookup table following its own permutation of the slots, taking
+// weight turns per round, which gives a near-perfectly even spread and
+// O(1) lookups while still moving only ~1/N of keys on membership changes.
+type maglev struct {
+	balancer *Balancer
+
+	mu      sync.RWMutex
+	members membership
+	table   []int // slot -> index of the backend in the slice the table was built from
+}
+
+func (s *maglev) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	table := s.lookupTable(backends)
+	hash := hashString(s.balancer.settings.Load().hashKey(r))
+	return &backends[table[hash%maglevTableSize]]
+}
+
+// lookupTable returns the table for backends, rebuilding it if the
+// membership has changed since it was last built. Tables are never
+// modified once built, so the result can be used without holding the lock.
+func (s *maglev) lookupTable(backends []registry.Backend) []int {
+	s.mu.RLock()
+	if s.members.matches(backends) {
+		defer s.mu.RUnlock()
+		return s.table
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another request may have rebuilt the table while we waited for the lock
+	if !s.members.matches(backends) {
+		s.table = buildMaglevTable(backends)
+		s.members = newMembership(backends)
+	}
+	return s.table
+}
+
+// buildMaglevTable populates a lookup table for backends
+func buildMaglevTable(backends []registry.Backend) []int {
+	offsets := make([]uint64, len(backends))
+	skips := make([]uint64, len(backends))
+	for i, backend := range backends {
+		offsets[i] = hashString(backend.Address+"#offset") % maglevTableSize
+		skips[i] = hashString(backend.Address+"#skip")%(maglevTableSize-1) + 1
+	}
+
+	table := make([]int, maglevTableSize)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, len(backends))
+
+	for filled := 0; filled < maglevTableSize; {
+		for i, backend := range backends {
+			for turn := 0; turn < backend.EffectiveWeight() && filled < maglevTableSize; turn++ {
+				slot := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				for table[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				}
+				table[slot] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+
+	return table
+}
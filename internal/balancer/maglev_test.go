@@ -0,0 +1,45 @@
+package balancer
+
+import "testing"
+
+func TestMaglevMovesFewKeys(t *testing.T) {
+	// Maglev trades perfect stability for an even spread: refilling the
+	// table shifts a few slots between backends that did not change
+	testKeyMovement(t, func(b *Balancer) Strategy { return &maglev{balancer: b} }, 0.01)
+}
+
+func TestMaglevTableSpreadsByWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{"equal", []int{1, 1, 1}},
+		{"three to one", []int{3, 1}},
+		{"mixed", []int{1, 2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := testBackends(len(tt.weights))
+			total := 0
+			for i, weight := range tt.weights {
+				backends[i].Weight = weight
+				total += weight
+			}
+
+			slots := make([]int, len(backends))
+			for _, index := range buildMaglevTable(backends) {
+				slots[index]++
+			}
+
+			// Maglev fills the table in turns, so shares are within a slot
+			// or so of the weights
+			for i, weight := range tt.weights {
+				want := maglevTableSize * weight / total
+				if diff := slots[i] - want; diff < -len(backends) || diff > len(backends) {
+					t.Errorf("backend %d with weight %d has %d slots, want %d", i, weight, slots[i], want)
+				}
+			}
+		})
+	}
+}
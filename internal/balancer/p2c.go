@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("p2c", func(b *Balancer) Strategy { return &powerOfTwoChoices{balancer: b} })
+}
+
+// unobservedLatency stands in for the latency of a backend that has not
+// served a request or answered a health check yet
+const unobservedLatency = 50 * time.Millisecond
+
+// powerOfTwoChoices samples two distinct backends at random and picks the
+// one with the lower cost, where the cost is the peak-EWMA latency
+// multiplied by the number of outstanding requests (plus one, so idle
+// backends are still ranked by latency).
+type powerOfTwoChoices struct {
+	balancer *Balancer
+}
+
+func (s *powerOfTwoChoices) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	n := len(backends)
+	if n == 1 {
+		return &backends[0]
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	if s.cost(backends[j].Address) < s.cost(backends[i].Address) {
+		return &backends[j]
+	}
+	return &backends[i]
+}
+
+// cost scores a backend for selection; lower is better
+func (s *powerOfTwoChoices) cost(address string) float64 {
+	stats := s.balancer.statsFor(address)
+
+	latency, observed := stats.averageLatency()
+	if !observed {
+		latency = unobservedLatency
+	}
+	return float64(latency) * float64(stats.active.Load()+1)
+}
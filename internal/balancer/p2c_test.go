@@ -0,0 +1,47 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func TestPowerOfTwoChoicesPicksLowerCost(t *testing.T) {
+	tests := []struct {
+		name     string
+		latency  [2]time.Duration // zero leaves the backend unobserved
+		active   [2]int
+		expected string
+	}{
+		{"lower latency", [2]time.Duration{10 * time.Millisecond, 30 * time.Millisecond}, [2]int{0, 0}, "a"},
+		{"fewer outstanding requests", [2]time.Duration{10 * time.Millisecond, 10 * time.Millisecond}, [2]int{3, 1}, "b"},
+		{"latency times outstanding requests", [2]time.Duration{10 * time.Millisecond, 40 * time.Millisecond}, [2]int{4, 0}, "b"},
+		{"unobserved backend", [2]time.Duration{0, 80 * time.Millisecond}, [2]int{0, 0}, "a"},
+		{"observed backend faster than unobserved", [2]time.Duration{0, 20 * time.Millisecond}, [2]int{0, 0}, "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBalancer(t)
+			backends := []registry.Backend{{Address: "a"}, {Address: "b"}}
+			for i, backend := range backends {
+				if tt.latency[i] > 0 {
+					b.ObserveLatency(backend.Address, tt.latency[i])
+				}
+				for n := 0; n < tt.active[i]; n++ {
+					b.Acquire(backend.Address)
+				}
+			}
+
+			// With two backends both are sampled every time, so the
+			// cheaper one must always win
+			s := &powerOfTwoChoices{balancer: b}
+			for i := 0; i < 100; i++ {
+				if got := s.Next(backends, nil).Address; got != tt.expected {
+					t.Fatalf("picked %s, want %s", got, tt.expected)
+				}
+			}
+		})
+	}
+}
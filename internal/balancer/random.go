@@ -0,0 +1,19 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("random", func(b *Balancer) Strategy { return &random{} })
+}
+
+// random picks a backend uniformly at random
+type random struct{}
+
+func (s *random) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	return &backends[rand.Intn(len(backends))]
+}
@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("ring_hash", func(b *Balancer) Strategy {
+		return &ringHash{balancer: b}
+	})
+}
+
+// defaultVirtualNodes is used when the configuration does not set a count
+const defaultVirtualNodes = 100
+
+// ringHash implements consistent hashing on a ring of virtual nodes. Each
+// backend is placed on the ring virtualNodes*weight times and a request is
+// routed to the first point clockwise from the hash of its key, so adding or
+// removing a backend only moves the keys that fall next to its points.
+type ringHash struct {
+	balancer *Balancer
+
+	mu      sync.RWMutex
+	members membership
+	points  []ringPoint
+}
+
+// ringPoint is one virtual node on the ring
+type ringPoint struct {
+	hash  uint64
+	index int // index of the backend in the slice the ring was built from
+}
+
+func (s *ringHash) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	points := s.ring(backends)
+	hash := hashString(s.balancer.settings.Load().hashKey(r))
+
+	i := sort.Search(len(points), func(i int) bool { return points[i].hash >= hash })
+	if i == len(points) {
+		i = 0
+	}
+	return &backends[points[i].index]
+}
+
+// ring returns the ring for backends, rebuilding it if the membership has
+// changed since it was last built. Rings are never modified once built, so
+// the result can be used without holding the lock.
+func (s *ringHash) ring(backends []registry.Backend) []ringPoint {
+	s.mu.RLock()
+	if s.members.matches(backends) {
+		defer s.mu.RUnlock()
+		return s.points
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another request may have rebuilt the ring while we waited for the lock
+	if !s.members.matches(backends) {
+		s.points = buildRing(backends, s.balancer.settings.Load().virtualNodes)
+		s.members = newMembership(backends)
+	}
+	return s.points
+}
+
+// buildRing places the virtual nodes of backends on a new ring
+func buildRing(backends []registry.Backend, virtualNodes int) []ringPoint {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	points := make([]ringPoint, 0, len(backends)*virtualNodes)
+	for i, backend := range backends {
+		for v := 0; v < virtualNodes*backend.EffectiveWeight(); v++ {
+			points = append(points, ringPoint{
+				hash:  hashString(backend.Address + "#" + strconv.Itoa(v)),
+				index: i,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	return points
+}
@@ -0,0 +1,20 @@
+package balancer
+
+import "testing"
+
+func TestRingHashMovesFewKeys(t *testing.T) {
+	testKeyMovement(t, func(b *Balancer) Strategy { return &ringHash{balancer: b} }, 0)
+}
+
+func TestRingHashIsStable(t *testing.T) {
+	s := &ringHash{balancer: newTestBalancer(t)}
+	backends := testBackends(5)
+
+	first := route(s, backends)
+	second := route(s, backends)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("key %d was routed to %s, then to %s", i, first[i], second[i])
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package balancer
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("round_robin", func(b *Balancer) Strategy { return &roundRobin{} })
+}
+
+// roundRobin hands out backends in turn
+type roundRobin struct {
+	counter atomic.Uint64
+}
+
+func (s *roundRobin) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	n := s.counter.Add(1) - 1
+	return &backends[n%uint64(len(backends))]
+}
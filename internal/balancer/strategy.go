@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"simple_load_balancer/internal/registry"
+)
+
+// Strategy picks the backend that should receive the next request.
+// Strategies that do not route on request attributes ignore r.
+type Strategy interface {
+	Next(backends []registry.Backend, r *http.Request) *registry.Backend
+}
+
+// Factory builds a Strategy bound to the Balancer that will use it
+type Factory func(b *Balancer) Strategy
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = make(map[string]Factory)
+)
+
+// Register makes a strategy available under the given algorithm name
+func Register(name string, factory Factory) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+
+	if _, exists := strategies[name]; exists {
+		panic(fmt.Sprintf("balancer: strategy %q registered twice", name))
+	}
+	strategies[name] = factory
+}
+
+// Algorithms returns the names of all registered strategies in sorted order
+func Algorithms() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newStrategy looks up the factory for name and builds a Strategy for b
+func newStrategy(name string, b *Balancer) (Strategy, error) {
+	strategiesMu.RLock()
+	factory, ok := strategies[name]
+	strategiesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown balancer algorithm %q (available: %v)", name, Algorithms())
+	}
+	return factory(b), nil
+}
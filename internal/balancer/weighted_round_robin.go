@@ -0,0 +1,61 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func init() {
+	Register("weighted_round_robin", func(b *Balancer) Strategy {
+		return &weightedRoundRobin{currentWeights: make(map[string]int)}
+	})
+}
+
+// weightedRoundRobin implements nginx's smooth weighted round-robin.
+// On every pick each backend's current weight grows by its configured
+// weight, the backend with the highest current weight wins and has the
+// total weight subtracted from it. Over a cycle every backend is chosen
+// exactly weight times, interleaved rather than in bursts.
+type weightedRoundRobin struct {
+	mu             sync.Mutex
+	currentWeights map[string]int
+}
+
+func (s *weightedRoundRobin) Next(backends []registry.Backend, r *http.Request) *registry.Backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *registry.Backend
+	total := 0
+
+	for i := range backends {
+		weight := backends[i].EffectiveWeight()
+		total += weight
+
+		current := s.currentWeights[backends[i].Address] + weight
+		s.currentWeights[backends[i].Address] = current
+
+		if best == nil || current > s.currentWeights[best.Address] {
+			best = &backends[i]
+		}
+	}
+
+	s.currentWeights[best.Address] -= total
+
+	// Forget backends that have left the registry
+	if len(s.currentWeights) > len(backends) {
+		present := make(map[string]bool, len(backends))
+		for _, backend := range backends {
+			present[backend.Address] = true
+		}
+		for address := range s.currentWeights {
+			if !present[address] {
+				delete(s.currentWeights, address)
+			}
+		}
+	}
+
+	return best
+}
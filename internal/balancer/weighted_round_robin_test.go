@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"testing"
+
+	"simple_load_balancer/internal/registry"
+)
+
+func TestWeightedRoundRobinSharesTrafficByWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{"three to one", []int{3, 1}},
+		{"unset weight counts as one", []int{0, 2}},
+		{"equal weights", []int{1, 1, 1}},
+		{"mixed", []int{5, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := make([]registry.Backend, len(tt.weights))
+			total := 0
+			for i, weight := range tt.weights {
+				backends[i] = registry.Backend{Address: string(rune('a' + i)), Weight: weight}
+				total += backends[i].EffectiveWeight()
+			}
+
+			// Every cycle of total picks gives each backend exactly its weight
+			const cycles = 10
+			s := &weightedRoundRobin{currentWeights: make(map[string]int)}
+			counts := make(map[string]int)
+			for i := 0; i < cycles*total; i++ {
+				counts[s.Next(backends, nil).Address]++
+			}
+
+			for _, backend := range backends {
+				if want := cycles * backend.EffectiveWeight(); counts[backend.Address] != want {
+					t.Errorf("backend %s with weight %d got %d picks, want %d", backend.Address, backend.Weight, counts[backend.Address], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRoundRobinInterleaves(t *testing.T) {
+	backends := []registry.Backend{{Address: "a", Weight: 3}, {Address: "b", Weight: 1}}
+	s := &weightedRoundRobin{currentWeights: make(map[string]int)}
+
+	// Smooth weighted round-robin spreads the heavy backend's picks around
+	// the light one instead of sending them back to back
+	var got string
+	for i := 0; i < 4; i++ {
+		got += s.Next(backends, nil).Address
+	}
+	if got != "aaba" {
+		t.Errorf("picks = %q, want %q", got, "aaba")
+	}
+}
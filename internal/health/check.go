@@ -0,0 +1,284 @@
+package health
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCheck is the name of the check used for backends that do not name one
+const DefaultCheck = "default"
+
+// Probe types
+const (
+	ProbeHTTP = "http"
+	ProbeGRPC = "grpc"
+)
+
+// CheckConfig describes how a group of backends is probed and what a
+// healthy answer looks like
+type CheckConfig struct {
+	// Type is ProbeHTTP (the default) or ProbeGRPC
+	Type   string
+	Path   string
+	Method string
+	// Scheme is "http" or "https"
+	Scheme string
+	// ExpectedStatus lists the accepted status codes as single codes ("204"),
+	// ranges ("200-299") or classes ("2xx"). Empty means exactly 200.
+	ExpectedStatus []string
+	// Headers are sent with every probe. A "Host" entry sets the request host.
+	Headers map[string]string
+	// BodyContains and BodyRegex, when set, must match the response body
+	BodyContains string
+	BodyRegex    string
+	// JSONPath is a dotted path into a JSON response body, e.g. "checks.db.status".
+	// The value found there must equal JSONValue, or merely exist if JSONValue is empty.
+	JSONPath  string
+	JSONValue string
+	// GRPCService is the service name sent in gRPC health requests; empty
+	// asks about the server as a whole
+	GRPCService string
+	// GRPCTLS enables TLS for gRPC probes
+	GRPCTLS bool
+	// TLS settings for https and gRPC-over-TLS probes
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
+}
+
+// Check is a compiled CheckConfig ready to evaluate probe responses
+type Check struct {
+	probeType    string
+	path         string
+	method       string
+	scheme       string
+	statusRanges []statusRange
+	headers      http.Header
+	host         string
+	bodyContains string
+	bodyRegex    *regexp.Regexp
+	jsonPath     []string
+	jsonValue    string
+	tlsConfig    *tls.Config
+	client       *http.Client
+	grpcService  string
+	grpcTLS      bool
+}
+
+// probeIdleTimeout closes probe connections left idle, e.g. to backends
+// that are no longer checked. It is longer than usual check intervals so
+// that probes reuse their connection.
+const probeIdleTimeout = 2 * time.Minute
+
+// statusRange is an inclusive range of accepted status codes
+type statusRange struct {
+	min, max int
+}
+
+// NewCheck validates cfg and compiles it into a Check
+func NewCheck(cfg CheckConfig) (*Check, error) {
+	c := &Check{
+		probeType:    strings.ToLower(cfg.Type),
+		grpcService:  cfg.GRPCService,
+		grpcTLS:      cfg.GRPCTLS,
+		path:         cfg.Path,
+		method:       strings.ToUpper(cfg.Method),
+		scheme:       strings.ToLower(cfg.Scheme),
+		headers:      make(http.Header),
+		bodyContains: cfg.BodyContains,
+		jsonValue:    cfg.JSONValue,
+	}
+	if c.probeType == "" {
+		c.probeType = ProbeHTTP
+	}
+	if c.probeType != ProbeHTTP && c.probeType != ProbeGRPC {
+		return nil, fmt.Errorf("unsupported probe type %q", cfg.Type)
+	}
+	if c.method == "" {
+		c.method = http.MethodGet
+	}
+	if c.scheme == "" {
+		c.scheme = "http"
+	}
+	if c.scheme != "http" && c.scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", cfg.Scheme)
+	}
+	if !strings.HasPrefix(c.path, "/") {
+		c.path = "/" + c.path
+	}
+
+	if len(cfg.ExpectedStatus) == 0 {
+		c.statusRanges = []statusRange{{http.StatusOK, http.StatusOK}}
+	}
+	for _, spec := range cfg.ExpectedStatus {
+		r, err := parseStatusRange(spec)
+		if err != nil {
+			return nil, err
+		}
+		c.statusRanges = append(c.statusRanges, r)
+	}
+
+	for name, value := range cfg.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			c.host = value
+			continue
+		}
+		c.headers.Set(name, value)
+	}
+
+	if cfg.BodyRegex != "" {
+		re, err := regexp.Compile(cfg.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body regex: %v", err)
+		}
+		c.bodyRegex = re
+	}
+	if cfg.JSONPath != "" {
+		c.jsonPath = strings.Split(cfg.JSONPath, ".")
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.TLSCAFile, cfg.TLSInsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	c.tlsConfig = tlsConfig
+	c.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, IdleConnTimeout: probeIdleTimeout},
+		// A redirect is an answer in its own right, judge it by its status
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	return c, nil
+}
+
+// Close closes the keep-alive connections the check holds to backends, once
+// it has been replaced or the checker has stopped
+func (c *Check) Close() {
+	c.client.CloseIdleConnections()
+}
+
+// newTLSConfig builds the client TLS settings for probes
+func newTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
+
+// parseStatusRange parses "200", "200-299" or "2xx"
+func parseStatusRange(spec string) (statusRange, error) {
+	spec = strings.TrimSpace(spec)
+
+	if len(spec) == 3 && strings.HasSuffix(strings.ToLower(spec), "xx") {
+		class, err := strconv.Atoi(spec[:1])
+		if err == nil && class >= 1 && class <= 5 {
+			return statusRange{class * 100, class*100 + 99}, nil
+		}
+	} else if lo, hi, ok := strings.Cut(spec, "-"); ok {
+		min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		max, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 == nil && err2 == nil && min <= max {
+			return statusRange{min, max}, nil
+		}
+	} else if code, err := strconv.Atoi(spec); err == nil {
+		return statusRange{code, code}, nil
+	}
+
+	return statusRange{}, fmt.Errorf("invalid expected status %q", spec)
+}
+
+// newRequest builds the probe request for a backend
+func (c *Check) newRequest(address string) (*http.Request, error) {
+	url := fmt.Sprintf("%s://%s%s", c.scheme, address, c.path)
+	req, err := http.NewRequest(c.method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.headers.Clone()
+	if c.host != "" {
+		req.Host = c.host
+	}
+	return req, nil
+}
+
+// evaluate checks a probe response against the expectations and returns
+// an error describing the first one that is not met
+func (c *Check) evaluate(status int, body []byte) error {
+	statusOK := false
+	for _, r := range c.statusRanges {
+		if status >= r.min && status <= r.max {
+			statusOK = true
+			break
+		}
+	}
+	if !statusOK {
+		return fmt.Errorf("unexpected status: %d", status)
+	}
+
+	if c.bodyContains != "" && !bytes.Contains(body, []byte(c.bodyContains)) {
+		return fmt.Errorf("response body does not contain %q", c.bodyContains)
+	}
+	if c.bodyRegex != nil && !c.bodyRegex.Match(body) {
+		return fmt.Errorf("response body does not match %q", c.bodyRegex)
+	}
+
+	if c.jsonPath != nil {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return fmt.Errorf("response body is not valid JSON: %v", err)
+		}
+		path := strings.Join(c.jsonPath, ".")
+		value, ok := lookupJSONPath(doc, c.jsonPath)
+		if !ok {
+			return fmt.Errorf("JSON path %q not found in response body", path)
+		}
+		if c.jsonValue != "" && fmt.Sprint(value) != c.jsonValue {
+			return fmt.Errorf("JSON path %q is %v, expected %s", path, value, c.jsonValue)
+		}
+	}
+
+	return nil
+}
+
+// lookupJSONPath walks a decoded JSON document along path. Numeric
+// segments index into arrays.
+func lookupJSONPath(doc interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			doc = value
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			doc = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
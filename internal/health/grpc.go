@@ -0,0 +1,39 @@
+package health
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// probeGRPC calls grpc.health.v1.Health/Check on a backend and reports an
+// error unless the service answers SERVING
+func (c *Check) probeGRPC(ctx context.Context, address string) error {
+	creds := insecure.NewCredentials()
+	if c.grpcTLS {
+		creds = credentials.NewTLS(c.tlsConfig)
+	}
+	options := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if c.host != "" {
+		options = append(options, grpc.WithAuthority(c.host))
+	}
+
+	conn, err := grpc.NewClient(address, options...)
+	if err != nil {
+		return fmt.Errorf("failed to create gRPC client: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: c.grpcService})
+	if err != nil {
+		return fmt.Errorf("gRPC health check failed: %v", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("gRPC health check returned status %s", resp.GetStatus())
+	}
+	return nil
+}
@@ -2,20 +2,76 @@ package health
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"math/rand"
 	"net"
-	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"simple_load_balancer/internal/logging"
 	"simple_load_balancer/internal/registry"
 )
 
 // HealthChecker periodically checks the health of backend servers
 type HealthChecker struct {
-	registry       *registry.Registry
-	checkInterval  time.Duration
-	timeout        time.Duration
-	healthEndpoint string
+	registry      *registry.Registry
+	settings      atomic.Pointer[settings]
+	resultHandler func(registry.Backend, HealthCheckResult)
+	changeHandler func(address string, previous, current registry.HealthState)
+
+	mu     sync.Mutex
+	states map[string]*backendState
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Config holds the configuration for the HealthChecker
+type Config struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// Checks maps check names to how backends using them are probed.
+	// Backends without a check name use DefaultCheck, which must be present.
+	Checks map[string]CheckConfig
+	// Rise is the number of consecutive successful checks needed to mark an
+	// unhealthy backend healthy again, and Fall the number of consecutive
+	// failed checks needed to mark a healthy backend unhealthy
+	Rise int
+	Fall int
+	// MaxBackoff enables exponential backoff of checks against backends
+	// that stay down, up to this interval. Zero disables backoff.
+	MaxBackoff time.Duration
+	// Jitter spreads checks randomly by up to this fraction of Interval
+	Jitter float64
+}
+
+// settings holds the parts of the HealthChecker that can be changed by
+// Reconfigure. They are replaced as a whole so a check never mixes values
+// from two configurations.
+type settings struct {
+	checkInterval time.Duration
+	timeout       time.Duration
+	checks        map[string]*Check
+	rise          int
+	fall          int
+	maxBackoff    time.Duration
+	jitter        float64
+}
+
+// backendState tracks the check results of one backend
+type backendState struct {
+	state                registry.HealthState
+	consecutiveSuccesses int
+	consecutiveFailures  int
+	nextCheck            time.Time
+	checking             bool
+	lastProbe            time.Time
+	lastLatency          time.Duration
+	lastError            string
+	history              history
 }
 
 // HealthCheckResult represents the result of a health check
@@ -23,16 +79,79 @@ type HealthCheckResult struct {
 	Healthy bool
 	Latency time.Duration
 	Error   error
+	// Load is the utilization percentage the backend reported in the
+	// "load" field of its health response, if LoadReported is set
+	Load         float64
+	LoadReported bool
 }
 
+// maxHealthBodySize caps how much of a health response body is read
+const maxHealthBodySize = 64 << 10
+
 // New creates and initializes a new HealthChecker
-func New(registry *registry.Registry, checkInterval, timeout time.Duration, healthEndpoint string) *HealthChecker {
-	return &HealthChecker{
-		registry:       registry,
-		checkInterval:  checkInterval,
-		timeout:        timeout,
-		healthEndpoint: healthEndpoint,
+func New(registry *registry.Registry, cfg Config) (*HealthChecker, error) {
+	h := &HealthChecker{
+		registry: registry,
+		states:   make(map[string]*backendState),
+		stop:     make(chan struct{}),
+	}
+	if err := h.Reconfigure(cfg); err != nil {
+		return nil, err
 	}
+	return h, nil
+}
+
+// Reconfigure changes the check settings while the checker is running. If
+// cfg is invalid the current settings are kept. The health state of each
+// backend is preserved; new thresholds apply from the next check on.
+func (h *HealthChecker) Reconfigure(cfg Config) error {
+	next := &settings{
+		checkInterval: cfg.Interval,
+		timeout:       cfg.Timeout,
+		checks:        make(map[string]*Check),
+		rise:          cfg.Rise,
+		fall:          cfg.Fall,
+		maxBackoff:    cfg.MaxBackoff,
+		jitter:        cfg.Jitter,
+	}
+	if next.rise < 1 {
+		next.rise = 1
+	}
+	if next.fall < 1 {
+		next.fall = 1
+	}
+
+	for name, checkConfig := range cfg.Checks {
+		check, err := NewCheck(checkConfig)
+		if err != nil {
+			return fmt.Errorf("health check %q: %v", name, err)
+		}
+		next.checks[name] = check
+	}
+	if _, ok := next.checks[DefaultCheck]; !ok {
+		return fmt.Errorf("health check %q is not configured", DefaultCheck)
+	}
+
+	// Close the connections of the checks being replaced. A probe still
+	// running with one leaves its connection to the idle timeout.
+	if previous := h.settings.Swap(next); previous != nil {
+		for _, check := range previous.checks {
+			check.Close()
+		}
+	}
+	return nil
+}
+
+// SetResultHandler sets a function that is called with the result of every
+// health check, e.g. to feed probe latency into the balancer
+func (h *HealthChecker) SetResultHandler(handler func(registry.Backend, HealthCheckResult)) {
+	h.resultHandler = handler
+}
+
+// SetStateChangeHandler sets a function that is called whenever the rise/fall
+// thresholds move a backend to a different health state
+func (h *HealthChecker) SetStateChangeHandler(handler func(address string, previous, current registry.HealthState)) {
+	h.changeHandler = handler
 }
 
 // Start begins the health checking loop in a separate goroutine
@@ -40,71 +159,240 @@ func (h *HealthChecker) Start() {
 	go h.checkLoop()
 }
 
-// checkLoop runs the health checks at regular intervals
+// Stop ends the health checking loop and closes idle probe connections.
+// Checks that are already running are allowed to finish but no new ones are
+// started.
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+		for _, check := range h.settings.Load().checks {
+			check.Close()
+		}
+	})
+}
+
+// checkLoop runs the health checks at regular, jittered intervals
 func (h *HealthChecker) checkLoop() {
-	ticker := time.NewTicker(h.checkInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(h.jittered(h.settings.Load()))
+	defer timer.Stop()
 
-	for range ticker.C {
-		h.checkBackends()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-timer.C:
+			h.checkBackends()
+			timer.Reset(h.jittered(h.settings.Load()))
+		}
 	}
 }
 
-// checkBackends initiates a health check for all registered backends
+// jittered randomly moves the check interval by up to the configured jitter
+// fraction in either direction
+func (h *HealthChecker) jittered(cfg *settings) time.Duration {
+	d := cfg.checkInterval
+	if cfg.jitter <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*cfg.jitter*float64(d))
+}
+
+// checkBackends initiates a health check for all registered backends that are due one
 func (h *HealthChecker) checkBackends() {
-	for _, backend := range h.registry.GetAll() {
+	backends := h.registry.GetAll()
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	present := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		present[backend.Address] = true
+
+		state, ok := h.states[backend.Address]
+		if !ok {
+			state = &backendState{state: backend.Health}
+			h.states[backend.Address] = state
+		}
+		if state.checking || now.Before(state.nextCheck) {
+			continue
+		}
+		state.checking = true
 		go h.checkBackend(backend)
 	}
+
+	// Forget backends that have been removed from the registry
+	for address := range h.states {
+		if !present[address] {
+			delete(h.states, address)
+		}
+	}
 }
 
 // checkBackend performs a comprehensive health check on a single backend
 func (h *HealthChecker) checkBackend(backend registry.Backend) {
+	// Spread the checks of a round over the jitter window so they do not
+	// all hit the network at the same instant
+	cfg := h.settings.Load()
+	if cfg.jitter > 0 {
+		time.Sleep(time.Duration(rand.Float64() * cfg.jitter * float64(cfg.checkInterval)))
+	}
+
 	result := h.performHealthCheck(backend)
+	if h.resultHandler != nil {
+		h.resultHandler(backend, result)
+	}
 
 	if !result.Healthy {
-		log.Printf("Backend %s is unhealthy: %v", backend.Address, result.Error)
-		h.registry.Remove(backend.Address)
+		logging.Warnf("Backend %s failed health check: %v", backend.Address, result.Error)
+	}
+	h.recordResult(backend.Address, result)
+}
+
+// recordResult applies the rise/fall thresholds to a check result and
+// updates the backend's health in the registry when it changes. Unhealthy
+// backends stay registered so they keep being probed and are put back into
+// rotation as soon as they recover.
+func (h *HealthChecker) recordResult(address string, result HealthCheckResult) {
+	cfg := h.settings.Load()
+	h.mu.Lock()
+
+	state, ok := h.states[address]
+	if !ok {
+		h.mu.Unlock()
+		return // removed from the registry while the check was running
+	}
+	state.checking = false
+	state.lastProbe = time.Now()
+	state.lastLatency = result.Latency
+	state.lastError = ""
+	if result.Error != nil {
+		state.lastError = result.Error.Error()
+	}
+
+	previous := state.state
+	if result.Healthy {
+		state.consecutiveSuccesses++
+		state.consecutiveFailures = 0
+		// The first result for a backend decides its state right away so
+		// new backends do not wait rise checks before taking traffic
+		if previous == registry.HealthUnknown || (previous == registry.HealthUnhealthy && state.consecutiveSuccesses >= cfg.rise) {
+			state.state = registry.HealthHealthy
+		}
+		state.nextCheck = time.Time{}
 	} else {
-		log.Printf("Backend %s is healthy (latency: %v)", backend.Address, result.Latency)
-		// Optionally, update the backend's status in the registry
-		// h.registry.UpdateStatus(backend.Address, result.Latency)
+		state.consecutiveFailures++
+		state.consecutiveSuccesses = 0
+		if previous == registry.HealthUnknown || (previous == registry.HealthHealthy && state.consecutiveFailures >= cfg.fall) {
+			state.state = registry.HealthUnhealthy
+		}
+		state.nextCheck = cfg.backoff(state)
+	}
+
+	current := state.state
+	if current != previous {
+		state.history.add(Transition{
+			At:     state.lastProbe,
+			From:   previous.String(),
+			To:     current.String(),
+			Reason: state.lastError,
+		})
+	}
+	h.mu.Unlock()
+
+	if current != previous {
+		logging.Infof("Backend %s is now %s (was %s)", address, current, previous)
+		h.registry.SetHealth(address, current)
+		if h.changeHandler != nil {
+			h.changeHandler(address, previous, current)
+		}
 	}
 }
 
+// backoff returns when a backend that keeps failing should be checked next.
+// Each failure beyond the one that marked it down doubles the interval, up
+// to the configured maximum.
+func (cfg *settings) backoff(state *backendState) time.Time {
+	if cfg.maxBackoff <= 0 || state.state != registry.HealthUnhealthy {
+		return time.Time{}
+	}
+
+	delay := cfg.checkInterval
+	for i := cfg.fall; i < state.consecutiveFailures && delay < cfg.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > cfg.maxBackoff {
+		delay = cfg.maxBackoff
+	}
+	return time.Now().Add(delay)
+}
+
 // performHealthCheck conducts a series of health checks on a backend
 func (h *HealthChecker) performHealthCheck(backend registry.Backend) HealthCheckResult {
+	cfg := h.settings.Load()
 	start := time.Now()
 
 	// 1. TCP Connection Check
-	conn, err := net.DialTimeout("tcp", backend.Address, h.timeout)
+	conn, err := net.DialTimeout("tcp", backend.Address, cfg.timeout)
 	if err != nil {
 		return HealthCheckResult{Healthy: false, Error: fmt.Errorf("TCP connection failed: %v", err)}
 	}
 	conn.Close()
 
-	// 2. HTTP Health Endpoint Check
-	url := fmt.Sprintf("http://%s%s", backend.Address, h.healthEndpoint)
-	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	check := cfg.checkFor(backend)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	// 2. gRPC health checking protocol, for backends that speak it
+	if check.probeType == ProbeGRPC {
+		if err := check.probeGRPC(ctx, backend.Address); err != nil {
+			return HealthCheckResult{Healthy: false, Error: err}
+		}
+		return HealthCheckResult{Healthy: true, Latency: time.Since(start)}
+	}
+
+	// 2. HTTP Health Endpoint Check
+	req, err := check.newRequest(backend.Address)
 	if err != nil {
 		return HealthCheckResult{Healthy: false, Error: fmt.Errorf("failed to create HTTP request: %v", err)}
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := check.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return HealthCheckResult{Healthy: false, Error: fmt.Errorf("HTTP health check failed: %v", err)}
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return HealthCheckResult{Healthy: false, Error: fmt.Errorf("HTTP health check returned non-200 status: %d", resp.StatusCode)}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodySize))
+	if err != nil {
+		return HealthCheckResult{Healthy: false, Error: fmt.Errorf("failed to read health response: %v", err)}
 	}
 
-	// 3. Additional checks can be added here (e.g., checking response body, verifying SSL certificates)
+	// 3. Compare the response with what the check expects
+	if err := check.evaluate(resp.StatusCode, body); err != nil {
+		return HealthCheckResult{Healthy: false, Error: fmt.Errorf("HTTP health check failed: %v", err)}
+	}
 
 	latency := time.Since(start)
-	return HealthCheckResult{Healthy: true, Latency: latency}
-}
\ No newline at end of file
+	result := HealthCheckResult{Healthy: true, Latency: latency}
+
+	// 4. Pick up the load the backend reports in a JSON body, if any
+	var report struct {
+		Load *float64 `json:"load"`
+	}
+	if err := json.Unmarshal(body, &report); err == nil && report.Load != nil {
+		result.Load = *report.Load
+		result.LoadReported = true
+	}
+
+	return result
+}
+
+// checkFor returns the check configured for a backend, falling back to the
+// default check for backends that name an unknown one
+func (cfg *settings) checkFor(backend registry.Backend) *Check {
+	if check, ok := cfg.checks[backend.HealthCheck]; ok {
+		return check
+	}
+	return cfg.checks[DefaultCheck]
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"time"
+
+	"simple_load_balancer/internal/registry"
+)
+
+// historySize is the number of state transitions kept per backend
+const historySize = 20
+
+// Transition records a backend moving from one health state to another
+type Transition struct {
+	At     time.Time `json:"at"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// history is a fixed-size ring buffer of the most recent transitions
+type history struct {
+	entries [historySize]Transition
+	next    int
+	count   int
+}
+
+func (h *history) add(t Transition) {
+	h.entries[h.next] = t
+	h.next = (h.next + 1) % historySize
+	if h.count < historySize {
+		h.count++
+	}
+}
+
+// list returns the recorded transitions from oldest to newest
+func (h *history) list() []Transition {
+	transitions := make([]Transition, 0, h.count)
+	start := (h.next - h.count + historySize) % historySize
+	for i := 0; i < h.count; i++ {
+		transitions = append(transitions, h.entries[(start+i)%historySize])
+	}
+	return transitions
+}
+
+// BackendStatus is a snapshot of what the health checker knows about a backend
+type BackendStatus struct {
+	Address              string       `json:"address"`
+	State                string       `json:"state"`
+	LastProbe            time.Time    `json:"last_probe"`
+	LastLatency          string       `json:"last_latency"`
+	LastError            string       `json:"last_error,omitempty"`
+	ConsecutiveSuccesses int          `json:"consecutive_successes"`
+	ConsecutiveFailures  int          `json:"consecutive_failures"`
+	Transitions          []Transition `json:"transitions"`
+}
+
+// Status returns the health status of every registered backend
+func (h *HealthChecker) Status() []BackendStatus {
+	backends := h.registry.GetAll()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	statuses := make([]BackendStatus, 0, len(backends))
+	for _, backend := range backends {
+		statuses = append(statuses, h.status(backend))
+	}
+	return statuses
+}
+
+// BackendStatus returns the health status of a single backend
+func (h *HealthChecker) BackendStatus(address string) (BackendStatus, bool) {
+	for _, backend := range h.registry.GetAll() {
+		if backend.Address == address {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return h.status(backend), true
+		}
+	}
+	return BackendStatus{}, false
+}
+
+// status builds the status of a backend. The caller must hold h.mu.
+func (h *HealthChecker) status(backend registry.Backend) BackendStatus {
+	status := BackendStatus{
+		Address:     backend.Address,
+		State:       backend.Health.String(),
+		Transitions: []Transition{},
+	}
+
+	state, ok := h.states[backend.Address]
+	if !ok {
+		return status
+	}
+	status.State = state.state.String()
+	status.LastProbe = state.lastProbe
+	status.LastError = state.lastError
+	status.ConsecutiveSuccesses = state.consecutiveSuccesses
+	status.ConsecutiveFailures = state.consecutiveFailures
+	status.Transitions = state.history.list()
+	if !state.lastProbe.IsZero() {
+		status.LastLatency = state.lastLatency.String()
+	}
+	return status
+}
@@ -1,18 +1,30 @@
 package listener
 
 import (
+	"context"
 	"crypto/tls"
-	"log"
+	"errors"
 	"net"
+	"net/http"
+	"sync"
 	"time"
+
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/upgrade"
 )
 
-// Listener handles incoming network connections
+// Listener serves HTTP on the load balancer's public socket with a single
+// long-lived http.Server
 type Listener struct {
 	address     string
 	tlsConfig   *tls.Config
-	handler     func(net.Conn)
 	idleTimeout time.Duration
+	server      *http.Server
+
+	mu       sync.Mutex
+	raw      net.Listener // the TCP socket, handed to a new process on upgrade
+	listener net.Listener // raw with idle deadlines, wrapped in TLS when TLS is enabled
+	closed   bool
 }
 
 // Config holds the configuration for the Listener
@@ -20,93 +32,138 @@ type Config struct {
 	Address     string
 	TLSCertFile string
 	TLSKeyFile  string
+	// ReadTimeout bounds reading a whole request, body included, and
+	// ReadHeaderTimeout reading its headers. Zero means no limit.
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout bounds writing a response. Zero means no limit.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a connection may sit idle, both before its
+	// first request and between keep-alive requests
 	IdleTimeout time.Duration
+	// MaxHeaderBytes caps the size of request headers
+	MaxHeaderBytes int
 }
 
 // New creates and initializes a new Listener
 func New(cfg Config) (*Listener, error) {
 	l := &Listener{
-			address:     cfg.Address,
-			idleTimeout: cfg.IdleTimeout,
+		address:     cfg.Address,
+		idleTimeout: cfg.IdleTimeout,
+		server: &http.Server{
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		},
 	}
 
 	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
-			cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
-			if err != nil {
-					return nil, err
-			}
-			l.tlsConfig = &tls.Config{
-					Certificates: []tls.Certificate{cert},
-			}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		l.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
 	}
 
 	return l, nil
 }
 
-// SetHandler sets the connection handler function
-func (l *Listener) SetHandler(handler func(net.Conn)) {
-	l.handler = handler
+// SetHandler sets the handler that serves every request
+func (l *Listener) SetHandler(handler http.Handler) {
+	l.server.Handler = handler
 }
 
-// Start begins listening for incoming connections and handles them
+// Start begins listening for incoming connections and serves them
 func (l *Listener) Start() error {
-	var listener net.Listener
-	var err error
-
-	if l.tlsConfig != nil {
-			listener, err = tls.Listen("tcp", l.address, l.tlsConfig)
-	} else {
-			listener, err = net.Listen("tcp", l.address)
+	if err := l.Listen(); err != nil {
+		return err
 	}
+	return l.Serve()
+}
 
+// Listen opens the listening socket, or takes over the one inherited from
+// the process that started this one during a binary upgrade
+func (l *Listener) Listen() error {
+	raw, err := upgrade.Listen("listen", l.address)
 	if err != nil {
-			return err
+		return err
 	}
-	defer listener.Close()
 
-	log.Printf("Listening on %s", l.address)
+	var listener net.Listener = &idleListener{Listener: raw, idleTimeout: l.idleTimeout}
+	if l.tlsConfig != nil {
+		listener = tls.NewListener(listener, l.tlsConfig)
+	}
 
-	for {
-			conn, err := listener.Accept()
-			if err != nil {
-					log.Printf("Error accepting connection: %v", err)
-					continue
-			}
-			go l.handleConnection(conn)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		raw.Close()
+		return nil
 	}
+	l.raw = raw
+	l.listener = listener
+
+	logging.Infof("Listening on %s", l.address)
+	return nil
 }
 
-// handleConnection processes a single client connection
-func (l *Listener) handleConnection(conn net.Conn) {
-	defer conn.Close()
+// Serve serves HTTP on the socket opened by Listen until Shutdown is called
+func (l *Listener) Serve() error {
+	l.mu.Lock()
+	listener := l.listener
+	l.mu.Unlock()
+	if listener == nil {
+		return nil // shut down before it started listening
+	}
 
-	// Set idle timeout
-	if l.idleTimeout > 0 {
-		err := conn.SetDeadline(time.Now().Add(l.idleTimeout))
-		if err != nil {
-			log.Printf("Error setting connection deadline: %v", err)
-			return
-		}
+	if err := l.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
+}
 
-	// Handle TLS handshake if TLS is enabled
-	if l.tlsConfig != nil {
-		tlsConn, ok := conn.(*tls.Conn)
-		if !ok {
-			log.Printf("Error: expected TLS connection")
-			return
-		}
-		err := tlsConn.Handshake()
-		if err != nil {
-			log.Printf("TLS handshake error: %v", err)
-			return
-		}
+// Socket returns the underlying TCP listener so it can be passed to a new
+// process during a binary upgrade
+func (l *Listener) Socket() net.Listener {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.raw
+}
+
+// Shutdown stops accepting new connections, closes idle ones and waits for
+// active requests to finish until ctx expires. Clients are told to close
+// their keep-alive connections after their current response.
+func (l *Listener) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+
+	return l.server.Shutdown(ctx)
+}
+
+// idleListener closes connections that do not start sending within the idle
+// timeout. Once a request arrives the http.Server manages the deadlines.
+type idleListener struct {
+	net.Listener
+	idleTimeout time.Duration
+}
+
+func (l *idleListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
 	}
 
-	// Call the user-defined handler
-	if l.handler != nil {
-		l.handler(conn)
-	} else {
-		log.Printf("Warning: no handler set for connection")
+	// Only the read side is limited, so a TLS handshake or request that has
+	// not arrived in time fails without cutting off a slow response
+	if l.idleTimeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(l.idleTimeout)); err != nil {
+			logging.Errorf("Error setting connection deadline: %v", err)
+		}
 	}
-}
\ No newline at end of file
+	return conn, nil
+}
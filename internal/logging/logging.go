@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// level is shared by every handler installed by Configure, so changing it
+// takes effect for loggers that were created earlier
+var level slog.LevelVar
+
+// Config holds the logging configuration
+type Config struct {
+	// Level is "debug", "info", "warn" or "error"
+	Level string
+	// Format is "text" or "json"
+	Format string
+}
+
+// Configure installs a default slog logger with the given level and format.
+// The load balancer logs through Debugf, Infof, Warnf and Errorf so the
+// level filters its messages; anything written with the standard log
+// package, such as by libraries, is routed through the logger at info
+// level. It can be called again at any time to change the configuration; if
+// cfg is invalid the current logger is kept.
+func Configure(cfg Config) error {
+	l, handler, err := newHandler(cfg)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Validate reports whether Configure would accept cfg
+func Validate(cfg Config) error {
+	_, _, err := newHandler(cfg)
+	return err
+}
+
+// ParseLevel parses a level name such as "debug" or "warn"
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", name)
+	}
+	return l, nil
+}
+
+// CheckFormat reports whether format is a supported log format
+func CheckFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "", "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("invalid log format %q (expected text or json)", format)
+	}
+}
+
+// newHandler parses the level in cfg and builds a handler for its format
+func newHandler(cfg Config) (slog.Level, slog.Handler, error) {
+	l, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := CheckFormat(cfg.Format); err != nil {
+		return 0, nil, err
+	}
+
+	options := &slog.HandlerOptions{Level: &level}
+	if strings.ToLower(cfg.Format) == "json" {
+		return l, slog.NewJSONHandler(os.Stderr, options), nil
+	}
+	return l, slog.NewTextHandler(os.Stderr, options), nil
+}
+
+// Debugf logs a message at debug level, formatted as by fmt.Sprintf
+func Debugf(format string, args ...interface{}) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+// Infof logs a message at info level, formatted as by fmt.Sprintf
+func Infof(format string, args ...interface{}) {
+	logf(slog.LevelInfo, format, args...)
+}
+
+// Warnf logs a message at warn level, formatted as by fmt.Sprintf
+func Warnf(format string, args ...interface{}) {
+	logf(slog.LevelWarn, format, args...)
+}
+
+// Errorf logs a message at error level, formatted as by fmt.Sprintf
+func Errorf(format string, args ...interface{}) {
+	logf(slog.LevelError, format, args...)
+}
+
+// logf logs through the default logger, skipping the formatting when the
+// level is disabled
+func logf(l slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	logger := slog.Default()
+	if logger.Enabled(ctx, l) {
+		logger.Log(ctx, l, fmt.Sprintf(format, args...))
+	}
+}
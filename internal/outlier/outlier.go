@@ -0,0 +1,159 @@
+package outlier
+
+import (
+	"sync"
+	"time"
+
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/registry"
+)
+
+// Detector ejects backends that keep failing live traffic, without waiting
+// for the next active health check. A backend is ejected after a run of
+// consecutive failures and stays out of rotation for an ejection time that
+// grows every time it is ejected again.
+type Detector struct {
+	registry           *registry.Registry
+	consecutiveErrors  int
+	baseEjectionTime   time.Duration
+	maxEjectionTime    time.Duration
+	maxEjectionPercent int
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// Config holds the configuration for the Detector
+type Config struct {
+	// ConsecutiveErrors is the number of failures in a row that ejects a backend
+	ConsecutiveErrors int
+	// BaseEjectionTime is multiplied by the number of times a backend has
+	// been ejected to get the length of its next ejection
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the length of a single ejection
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent caps the share of the fleet that may be ejected at
+	// once. At least one backend can always be ejected.
+	MaxEjectionPercent int
+}
+
+// hostState tracks the outcomes of live traffic to one backend
+type hostState struct {
+	consecutiveErrors int
+	ejections         int
+	ejectedUntil      time.Time
+}
+
+// New creates and initializes a new Detector
+func New(registry *registry.Registry, cfg Config) *Detector {
+	return &Detector{
+		registry:           registry,
+		consecutiveErrors:  cfg.ConsecutiveErrors,
+		baseEjectionTime:   cfg.BaseEjectionTime,
+		maxEjectionTime:    cfg.MaxEjectionTime,
+		maxEjectionPercent: cfg.MaxEjectionPercent,
+		hosts:              make(map[string]*hostState),
+	}
+}
+
+// ReportSuccess records a request that a backend served successfully
+func (d *Detector) ReportSuccess(address string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if host, ok := d.hosts[address]; ok {
+		host.consecutiveErrors = 0
+	}
+}
+
+// ReportFailure records a connection error, timeout or 5xx response from a
+// backend and ejects it once it has failed too many times in a row
+func (d *Detector) ReportFailure(address string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	host := d.host(address)
+	host.consecutiveErrors++
+
+	now := time.Now()
+	if host.consecutiveErrors < d.consecutiveErrors || now.Before(host.ejectedUntil) {
+		return
+	}
+	if !d.canEject(now) {
+		logging.Warnf("Not ejecting backend %s: max ejection percent (%d%%) reached", address, d.maxEjectionPercent)
+		return
+	}
+
+	// A backend that has behaved for longer than the longest ejection
+	// starts over at the base ejection time
+	if !host.ejectedUntil.IsZero() && now.Sub(host.ejectedUntil) > d.maxEjectionTime {
+		host.ejections = 0
+	}
+	host.ejections++
+	host.consecutiveErrors = 0
+
+	ejectionTime := d.baseEjectionTime * time.Duration(host.ejections)
+	if ejectionTime > d.maxEjectionTime {
+		ejectionTime = d.maxEjectionTime
+	}
+	host.ejectedUntil = now.Add(ejectionTime)
+
+	logging.Warnf("Ejecting backend %s for %v after %d consecutive failures", address, ejectionTime, d.consecutiveErrors)
+}
+
+// IsEjected reports whether a backend is currently ejected
+func (d *Detector) IsEjected(address string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	host, ok := d.hosts[address]
+	return ok && time.Now().Before(host.ejectedUntil)
+}
+
+// Restore puts an ejected backend back into rotation, e.g. once an active
+// health check has succeeded. Its ejection count is kept, so a backend that
+// keeps failing live traffic is still ejected for longer each time.
+func (d *Detector) Restore(address string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	host, ok := d.hosts[address]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if now.Before(host.ejectedUntil) {
+		logging.Infof("Restoring ejected backend %s", address)
+		host.ejectedUntil = now
+	}
+	host.consecutiveErrors = 0
+}
+
+// host returns the state of a backend, creating it on first use
+func (d *Detector) host(address string) *hostState {
+	host, ok := d.hosts[address]
+	if !ok {
+		host = &hostState{}
+		d.hosts[address] = host
+	}
+	return host
+}
+
+// canEject reports whether one more backend may be ejected without
+// exceeding the max ejection percent
+func (d *Detector) canEject(now time.Time) bool {
+	backends := d.registry.GetAll()
+
+	ejected := 0
+	for _, backend := range backends {
+		if host, ok := d.hosts[backend.Address]; ok && now.Before(host.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	allowed := len(backends) * d.maxEjectionPercent / 100
+	if allowed < 1 {
+		allowed = 1
+	}
+	return ejected < allowed
+}
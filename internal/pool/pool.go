@@ -1,146 +1,519 @@
 package pool
 
 import (
+	"context"
+	"errors"
 	"net"
+	"net/http/httptrace"
+	"os"
 	"sync"
 	"time"
-	"errors"
 )
 
-// ConnectionWrapper wraps a net.Conn with creation time information
-type ConnectionWrapper struct {
-	conn       net.Conn
+// dialTimeout bounds how long connecting to a backend may take
+const dialTimeout = 10 * time.Second
+
+// Conn is a backend connection managed by the pool. Closing it releases its
+// slot in the backend's connection limit.
+type Conn struct {
+	net.Conn
+	pool       *Pool
+	backend    *backendPool
 	createdAt  time.Time
-	lastUsedAt time.Time
+	lastUsedAt time.Time // when it last went idle, for the idle timeout
+	inUse      bool      // serving a request, as reported by the transport
+	evicted    bool      // closed by the pool, which counted why
+	closeOnce  sync.Once
+}
+
+// Close closes the connection and frees its slot for another one
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		err = c.Conn.Close()
+		c.pool.release(c)
+	})
+	return err
+}
+
+// backendPool tracks the connections to one backend
+type backendPool struct {
+	address string
+	open    int            // connections dialed or being dialed and not yet closed
+	inUse   int            // connections serving a request
+	conns   map[*Conn]bool // every open connection
+	spares  []*Conn        // connections dialed but not yet handed out
+	waiters []chan struct{}
+	stats   BackendStats // counters only; Stats fills in the rest
+	removed bool         // forgotten once its last connection closes
 }
 
-// Pool manages a pool of reusable network connections
+// Pool dials and accounts for the connections to each backend. It is used
+// as the DialContext of the proxy transport, which keeps reusable
+// connections idle between requests; the pool caps how many connections
+// each backend may have open, hands out spare connections first and closes
+// idle connections once they expire.
 type Pool struct {
-	connections     map[string][]*ConnectionWrapper
+	backends        map[string]*backendPool
 	mu              sync.Mutex
 	maxConns        int
+	minIdle         int
 	idleTimeout     time.Duration
 	maxLifetime     time.Duration
 	cleanupInterval time.Duration
+	waitTimeout     time.Duration
+	dialer          *net.Dialer
+	done            chan struct{}
+	closeOnce       sync.Once
 }
 
 // PoolConfig holds configuration for the connection pool
 type PoolConfig struct {
-	MaxConns        int
+	// MaxConns caps the open connections to each backend, idle ones included
+	MaxConns int
+	// MinIdle is how many connections Warm dials ahead of the first requests
+	MinIdle         int
 	IdleTimeout     time.Duration
 	MaxLifetime     time.Duration
 	CleanupInterval time.Duration
+	// WaitTimeout is how long a dial waits for a free slot when a backend
+	// is at MaxConns. Zero fails such dials straight away.
+	WaitTimeout time.Duration
 }
 
 // New creates and initializes a new connection Pool
 func New(config PoolConfig) *Pool {
 	p := &Pool{
-		connections:     make(map[string][]*ConnectionWrapper),
+		backends:        make(map[string]*backendPool),
 		maxConns:        config.MaxConns,
+		minIdle:         config.MinIdle,
 		idleTimeout:     config.IdleTimeout,
 		maxLifetime:     config.MaxLifetime,
 		cleanupInterval: config.CleanupInterval,
+		waitTimeout:     config.WaitTimeout,
+		dialer:          &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second},
+		done:            make(chan struct{}),
 	}
 	go p.periodicCleanup()
 	return p
 }
 
-// Get retrieves a connection from the pool or creates a new one if none are available
-func (p *Pool) Get(address string) (net.Conn, error) {
+// Reconfigure changes the pool limits while it is in use. Connections that
+// exceed a lowered limit are not closed, but no new ones are dialed until
+// the backend is back under it.
+func (p *Pool) Reconfigure(config PoolConfig) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	now := time.Now()
+	p.maxConns = config.MaxConns
+	p.minIdle = config.MinIdle
+	p.idleTimeout = config.IdleTimeout
+	p.maxLifetime = config.MaxLifetime
+	p.cleanupInterval = config.CleanupInterval
+	p.waitTimeout = config.WaitTimeout
+}
 
-	if conns, ok := p.connections[address]; ok && len(conns) > 0 {
-		for i, wrapper := range conns {
-			if p.isConnectionValid(wrapper, now) {
-				// Remove the connection from the slice
-				p.connections[address] = append(conns[:i], conns[i+1:]...)
-				wrapper.lastUsedAt = now
-				return wrapper.conn, nil
-			}
+// DialContext returns a connection to address, reusing a live spare if
+// there is one and dialing otherwise. If the backend is at its connection
+// limit it waits up to the wait timeout for a slot and then fails with
+// ErrPoolExhausted. It has the signature of http.Transport.DialContext.
+func (p *Pool) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	// Hand out a spare if one is still usable
+	for {
+		conn := p.takeSpare(address)
+		if conn == nil {
+			break
+		}
+		if !alive(conn.Conn) {
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+	return p.dial(ctx, network, address, true)
+}
+
+// Warm dials connections to address until it has the configured minimum of
+// idle ones, so the first requests to a new backend do not wait for a
+// connection to be set up. It stops at the connection limit rather than wait.
+func (p *Pool) Warm(address string) error {
+	p.mu.Lock()
+	backend := p.backend(address)
+	need := p.minIdle - (len(backend.conns) - backend.inUse)
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	for ; need > 0; need-- {
+		conn, err := p.dial(ctx, "tcp", address, false)
+		if errors.Is(err, ErrPoolExhausted) {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
+		p.Put(conn)
 	}
+	return nil
+}
 
-	// If no valid connection is available, create a new one
-	if len(p.connections[address]) >= p.maxConns {
-		return nil, ErrPoolExhausted
+// dial opens a new connection to address once the backend has a free slot,
+// waiting for one if wait is set. The dial itself happens without holding
+// the lock so a slow backend does not hold up connections to the others.
+func (p *Pool) dial(ctx context.Context, network, address string, wait bool) (*Conn, error) {
+	p.mu.Lock()
+	backend := p.backend(address)
+	if err := p.reserve(ctx, backend, wait); err != nil {
+		p.mu.Unlock()
+		return nil, err
 	}
+	backend.stats.Dials++
+	p.mu.Unlock()
 
-	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	raw, err := p.dialer.DialContext(ctx, network, address)
 	if err != nil {
+		p.mu.Lock()
+		backend.open--
+		backend.stats.DialFailures++
+		p.wakeWaiter(backend)
+		p.forget(backend)
+		p.mu.Unlock()
 		return nil, err
 	}
 
+	now := time.Now()
+	conn := &Conn{Conn: raw, pool: p, backend: backend, createdAt: now, lastUsedAt: now}
+	p.mu.Lock()
+	backend.conns[conn] = true
+	p.mu.Unlock()
 	return conn, nil
 }
 
-// Put adds a connection back to the pool for reuse
-func (p *Pool) Put(address string, conn net.Conn) {
+// takeSpare removes the most recently added spare connection to address
+// from the pool, closing those that have expired on the way
+func (p *Pool) takeSpare(address string) *Conn {
+	var expired []*Conn
+	defer func() {
+		for _, conn := range expired {
+			conn.Close()
+		}
+	}()
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	backend, ok := p.backends[address]
+	if !ok {
+		return nil
+	}
 	now := time.Now()
-	wrapper := &ConnectionWrapper{
-		conn:       conn,
-		createdAt:  now,
-		lastUsedAt: now,
+	for len(backend.spares) > 0 {
+		conn := backend.spares[len(backend.spares)-1]
+		backend.spares = backend.spares[:len(backend.spares)-1]
+		if !p.evict(conn, now) {
+			return conn
+		}
+		expired = append(expired, conn)
+	}
+	return nil
+}
+
+// reserve claims a connection slot on backend, waiting for one if the
+// backend is at its limit and wait is set. A wait ends with
+// ErrBackendRemoved if the backend is removed meanwhile. It is called with
+// p.mu held and returns with it held.
+func (p *Pool) reserve(ctx context.Context, backend *backendPool, wait bool) error {
+	if p.maxConns <= 0 || backend.open < p.maxConns {
+		backend.open++
+		return nil
+	}
+	if !wait || p.waitTimeout <= 0 {
+		return ErrPoolExhausted
+	}
+
+	backend.stats.Waits++
+	timer := time.NewTimer(p.waitTimeout)
+	defer timer.Stop()
+	for backend.open >= p.maxConns {
+		wake := make(chan struct{})
+		backend.waiters = append(backend.waiters, wake)
+		p.mu.Unlock()
+
+		var err error
+		select {
+		case <-wake:
+		case <-timer.C:
+			err = ErrPoolExhausted
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		p.mu.Lock()
+		if err != nil {
+			if !p.removeWaiter(backend, wake) {
+				// We were woken as we gave up; pass the slot on
+				p.wakeWaiter(backend)
+			}
+			return err
+		}
+		if backend.removed {
+			return ErrBackendRemoved
+		}
+	}
+	backend.open++
+	return nil
+}
+
+// removeWaiter takes wake out of the wait queue and reports whether it was
+// still queued
+func (p *Pool) removeWaiter(backend *backendPool, wake chan struct{}) bool {
+	for i, w := range backend.waiters {
+		if w == wake {
+			backend.waiters = append(backend.waiters[:i], backend.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// wakeWaiter wakes the longest waiting dial for backend, if any
+func (p *Pool) wakeWaiter(backend *backendPool) {
+	if len(backend.waiters) == 0 {
+		return
+	}
+	close(backend.waiters[0])
+	backend.waiters = backend.waiters[1:]
+}
+
+// Put adds a connection to the pool as a spare, to be handed out by the
+// next dial to its backend
+func (p *Pool) Put(conn *Conn) {
+	p.mu.Lock()
+	removed := conn.backend.removed
+	if !removed {
+		conn.lastUsedAt = time.Now()
+		conn.backend.spares = append(conn.backend.spares, conn)
 	}
+	p.mu.Unlock()
 
-	if len(p.connections[address]) < p.maxConns {
-		p.connections[address] = append(p.connections[address], wrapper)
-	} else {
+	if removed {
 		conn.Close()
 	}
 }
 
-// isConnectionValid checks if a connection is still valid based on idle timeout and max lifetime
-func (p *Pool) isConnectionValid(wrapper *ConnectionWrapper, now time.Time) bool {
-	if now.Sub(wrapper.lastUsedAt) > p.idleTimeout {
-		wrapper.conn.Close()
+// release frees the slot of a connection that has been closed
+func (p *Pool) release(conn *Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	backend := conn.backend
+	// The transport closes idle connections itself too; count those that
+	// had reached the idle timeout as if the pool had evicted them
+	if !conn.evicted && !conn.inUse && p.idleTimeout > 0 && time.Since(conn.lastUsedAt) >= p.idleTimeout {
+		backend.stats.EvictedIdleTimeout++
+	}
+	backend.open--
+	delete(backend.conns, conn)
+	if conn.inUse {
+		conn.inUse = false
+		backend.inUse--
+	}
+	removeSpare(backend, conn)
+	p.wakeWaiter(backend)
+	p.forget(backend)
+}
+
+// removeSpare takes conn out of the backend's spares if it is one
+func removeSpare(backend *backendPool, conn *Conn) {
+	for i, spare := range backend.spares {
+		if spare == conn {
+			backend.spares = append(backend.spares[:i], backend.spares[i+1:]...)
+			return
+		}
+	}
+}
+
+// backend returns the accounting for address, creating it on first use.
+// A backend dialed again after it was removed is kept from then on. It is
+// called with p.mu held.
+func (p *Pool) backend(address string) *backendPool {
+	backend, ok := p.backends[address]
+	if !ok {
+		backend = &backendPool{address: address, conns: make(map[*Conn]bool)}
+		p.backends[address] = backend
+	}
+	backend.removed = false
+	return backend
+}
+
+// forget drops the accounting for a removed backend once it has no
+// connections left. It is called with p.mu held.
+func (p *Pool) forget(backend *backendPool) {
+	if backend.removed && backend.open == 0 && p.backends[backend.address] == backend {
+		delete(p.backends, backend.address)
+	}
+}
+
+// WithTrace returns a context that reports to the pool when the proxy
+// transport hands a connection to a request and when it takes it back.
+// Requests sent through the pool's dialer should use it so the pool knows
+// which connections are idle, and so connections past their maximum
+// lifetime are closed instead of being kept for reuse.
+func (p *Pool) WithTrace(ctx context.Context) context.Context {
+	var conn *Conn
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn, _ = info.Conn.(*Conn)
+			if conn != nil {
+				p.setInUse(conn, true, info.Reused)
+			}
+		},
+		PutIdleConn: func(err error) {
+			if conn != nil && p.setInUse(conn, false, false) && err == nil {
+				conn.Close()
+			}
+		},
+	})
+}
+
+// setInUse records whether a connection is serving a request. When the
+// connection goes idle it reports whether it should be closed rather than
+// kept for reuse, because it has outlived its maximum lifetime or its
+// backend has been removed.
+func (p *Pool) setInUse(conn *Conn, inUse, reused bool) (expired bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	backend := conn.backend
+	if reused {
+		backend.stats.Reused++
+	}
+	if conn.inUse == inUse {
+		return false
+	}
+	conn.inUse = inUse
+	if inUse {
+		backend.inUse++
 		return false
 	}
-	if now.Sub(wrapper.createdAt) > p.maxLifetime {
-		wrapper.conn.Close()
+	backend.inUse--
+	conn.lastUsedAt = time.Now()
+	return backend.removed || p.evict(conn, conn.lastUsedAt)
+}
+
+// Remove closes every connection to address that is not serving a request
+// and fails the dials waiting for one. Connections still serving requests
+// stay accounted for and are closed as soon as they go idle; the backend is
+// forgotten once the last one closes.
+func (p *Pool) Remove(address string) {
+	p.mu.Lock()
+	var idle []*Conn
+	if backend, ok := p.backends[address]; ok {
+		for conn := range backend.conns {
+			if !conn.inUse {
+				idle = append(idle, conn)
+			}
+		}
+		backend.spares = nil
+		backend.removed = true
+		for _, wake := range backend.waiters {
+			close(wake)
+		}
+		backend.waiters = nil
+		p.forget(backend)
+	}
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
+
+// evict reports whether an idle connection has outlived the maximum
+// lifetime or sat idle longer than the idle timeout, counting the eviction
+// if so. It is called with p.mu held.
+func (p *Pool) evict(conn *Conn, now time.Time) bool {
+	switch {
+	case p.maxLifetime > 0 && now.Sub(conn.createdAt) > p.maxLifetime:
+		conn.backend.stats.EvictedMaxLifetime++
+	case p.idleTimeout > 0 && now.Sub(conn.lastUsedAt) > p.idleTimeout:
+		conn.backend.stats.EvictedIdleTimeout++
+	default:
 		return false
 	}
+	conn.evicted = true
 	return true
 }
 
-// periodicCleanup removes expired connections from the pool
+// alive checks that the backend has not closed a spare connection. A live
+// idle connection has nothing to read, so the read times out.
+func alive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// periodicCleanup closes idle connections that have expired, both spares
+// and those the proxy transport keeps for reuse
 func (p *Pool) periodicCleanup() {
-	ticker := time.NewTicker(p.cleanupInterval)
-	defer ticker.Stop()
+	p.mu.Lock()
+	timer := time.NewTimer(p.cleanupInterval)
+	p.mu.Unlock()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-timer.C:
+		}
 
-	for range ticker.C {
 		p.mu.Lock()
 		now := time.Now()
-		for address, conns := range p.connections {
-			validConns := make([]*ConnectionWrapper, 0, len(conns))
-			for _, wrapper := range conns {
-				if p.isConnectionValid(wrapper, now) {
-					validConns = append(validConns, wrapper)
+		var expired []*Conn
+		for _, backend := range p.backends {
+			for conn := range backend.conns {
+				if !conn.inUse && p.evict(conn, now) {
+					removeSpare(backend, conn)
+					expired = append(expired, conn)
 				}
 			}
-			p.connections[address] = validConns
 		}
+		// Read the interval on every round so Reconfigure takes effect
+		timer.Reset(p.cleanupInterval)
 		p.mu.Unlock()
+
+		for _, conn := range expired {
+			conn.Close()
+		}
 	}
 }
 
-// Close closes all connections in the pool
+// Close closes the spare connections and stops the cleanup loop.
+// Connections held by the proxy transport are closed by the transport.
 func (p *Pool) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	var spares []*Conn
+	for _, backend := range p.backends {
+		spares = append(spares, backend.spares...)
+		backend.spares = nil
+	}
+	p.mu.Unlock()
 
-	for _, conns := range p.connections {
-		for _, wrapper := range conns {
-			wrapper.conn.Close()
-		}
+	for _, conn := range spares {
+		conn.Close()
 	}
-	p.connections = make(map[string][]*ConnectionWrapper)
 }
 
-// ErrPoolExhausted is returned when the pool has reached its maximum number of connections
-var ErrPoolExhausted = errors.New("connection pool exhausted")
\ No newline at end of file
+// ErrPoolExhausted is returned when a backend has reached its maximum number of connections
+var ErrPoolExhausted = errors.New("connection pool exhausted")
+
+// ErrBackendRemoved is returned to dials that were waiting for a connection
+// to a backend when it was removed
+var ErrBackendRemoved = errors.New("backend removed from the connection pool")
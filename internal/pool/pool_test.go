@@ -0,0 +1,280 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// listen starts a backend that accepts connections and holds them open
+// until the test ends, and returns its address
+func listen(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// newTestPool returns a pool with cfg that is closed when the test ends
+func newTestPool(t *testing.T, cfg PoolConfig) *Pool {
+	t.Helper()
+	if cfg.CleanupInterval == 0 {
+		cfg.CleanupInterval = time.Hour
+	}
+	p := New(cfg)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// statsFor returns the statistics of address, or false if the pool does not
+// track it
+func statsFor(p *Pool, address string) (BackendStats, bool) {
+	for _, stats := range p.Stats() {
+		if stats.Address == address {
+			return stats, true
+		}
+	}
+	return BackendStats{}, false
+}
+
+// dialAsync starts a dial to address and returns where its result arrives
+func dialAsync(p *Pool, address string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		conn, err := p.DialContext(context.Background(), "tcp", address)
+		if err == nil {
+			defer conn.Close()
+		}
+		result <- err
+	}()
+	return result
+}
+
+func TestDialCapsConnections(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxConns int
+		dials    int
+		opened   int
+	}{
+		{"under the cap", 3, 2, 2},
+		{"at the cap", 2, 2, 2},
+		{"over the cap", 2, 5, 2},
+		{"no cap", 0, 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := listen(t)
+			p := newTestPool(t, PoolConfig{MaxConns: tt.maxConns})
+
+			opened := 0
+			for i := 0; i < tt.dials; i++ {
+				conn, err := p.DialContext(context.Background(), "tcp", address)
+				if errors.Is(err, ErrPoolExhausted) {
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				opened++
+			}
+
+			if opened != tt.opened {
+				t.Errorf("%d dials succeeded, want %d", opened, tt.opened)
+			}
+			if stats, _ := statsFor(p, address); stats.Open != tt.opened {
+				t.Errorf("stats report %d open connections, want %d", stats.Open, tt.opened)
+			}
+		})
+	}
+}
+
+func TestDialWaitsForFreeSlot(t *testing.T) {
+	tests := []struct {
+		name        string
+		waitTimeout time.Duration
+		closeAfter  time.Duration // zero keeps the connection open
+		wantErr     error
+	}{
+		{"slot freed in time", time.Second, 20 * time.Millisecond, nil},
+		{"wait times out", 50 * time.Millisecond, 0, ErrPoolExhausted},
+		{"no waiting", 0, 20 * time.Millisecond, ErrPoolExhausted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := listen(t)
+			p := newTestPool(t, PoolConfig{MaxConns: 1, WaitTimeout: tt.waitTimeout})
+
+			conn, err := p.DialContext(context.Background(), "tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.closeAfter > 0 {
+				time.AfterFunc(tt.closeAfter, func() { conn.Close() })
+			} else {
+				defer conn.Close()
+			}
+
+			if err := <-dialAsync(p, address); !errors.Is(err, tt.wantErr) {
+				t.Errorf("waiting dial returned %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	address := listen(t)
+	p := newTestPool(t, PoolConfig{MaxConns: 2, WaitTimeout: time.Minute})
+
+	// One connection serves a request, one is idle and one dial waits
+	busy, err := p.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.setInUse(busy.(*Conn), true, false)
+	idle, err := p.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting := dialAsync(p, address)
+	for stats, _ := statsFor(p, address); stats.Waits == 0; stats, _ = statsFor(p, address) {
+		time.Sleep(time.Millisecond)
+	}
+
+	p.Remove(address)
+
+	if err := <-waiting; !errors.Is(err, ErrBackendRemoved) {
+		t.Errorf("waiting dial returned %v, want %v", err, ErrBackendRemoved)
+	}
+	if _, err := idle.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("idle connection read returned %v, want it closed", err)
+	}
+
+	// The busy connection stays tracked until it is done
+	stats, ok := statsFor(p, address)
+	if !ok || stats.Open != 1 || stats.InUse != 1 {
+		t.Fatalf("stats after Remove = %+v, want one connection in use", stats)
+	}
+	if closeIt := p.setInUse(busy.(*Conn), false, false); !closeIt {
+		t.Error("connection of a removed backend was kept for reuse")
+	}
+	busy.Close()
+	if stats, ok := statsFor(p, address); ok {
+		t.Errorf("removed backend still tracked after its last connection closed: %+v", stats)
+	}
+	if stats.Dials != 2 {
+		t.Errorf("%d dials, want 2", stats.Dials)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	tests := []struct {
+		name         string
+		idleTimeout  time.Duration
+		maxLifetime  time.Duration
+		idleEvicted  uint64
+		lifeEvicted  uint64
+		stillTracked bool
+	}{
+		{"idle timeout", 20 * time.Millisecond, 0, 1, 0, false},
+		{"maximum lifetime", 0, 20 * time.Millisecond, 0, 1, false},
+		{"neither expired", time.Hour, time.Hour, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := listen(t)
+			p := newTestPool(t, PoolConfig{
+				IdleTimeout:     tt.idleTimeout,
+				MaxLifetime:     tt.maxLifetime,
+				CleanupInterval: 10 * time.Millisecond,
+			})
+
+			conn, err := p.DialContext(context.Background(), "tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			time.Sleep(100 * time.Millisecond)
+
+			stats, _ := statsFor(p, address)
+			if stats.EvictedIdleTimeout != tt.idleEvicted || stats.EvictedMaxLifetime != tt.lifeEvicted {
+				t.Errorf("evictions: idle timeout %d, maximum lifetime %d; want %d and %d",
+					stats.EvictedIdleTimeout, stats.EvictedMaxLifetime, tt.idleEvicted, tt.lifeEvicted)
+			}
+			if open := stats.Open == 1; open != tt.stillTracked {
+				t.Errorf("connection open = %v, want %v", open, tt.stillTracked)
+			}
+		})
+	}
+}
+
+func TestEvictionSkipsConnectionsInUse(t *testing.T) {
+	address := listen(t)
+	p := newTestPool(t, PoolConfig{IdleTimeout: 10 * time.Millisecond, CleanupInterval: 10 * time.Millisecond})
+
+	conn, err := p.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.setInUse(conn.(*Conn), true, false)
+	time.Sleep(50 * time.Millisecond)
+
+	if stats, _ := statsFor(p, address); stats.Open != 1 || stats.EvictedIdleTimeout != 0 {
+		t.Errorf("stats = %+v, want the connection in use left open", stats)
+	}
+}
+
+func TestCloseCountsIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		idle    time.Duration
+		inUse   bool
+		evicted uint64
+	}{
+		{"closed after the idle timeout", 50 * time.Millisecond, false, 1},
+		{"closed before the idle timeout", 0, false, 0},
+		{"closed while in use", 50 * time.Millisecond, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := listen(t)
+			p := newTestPool(t, PoolConfig{IdleTimeout: 20 * time.Millisecond})
+
+			conn, err := p.DialContext(context.Background(), "tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The transport closing its own idle connections looks like this
+			p.setInUse(conn.(*Conn), true, false)
+			if !tt.inUse {
+				p.setInUse(conn.(*Conn), false, false)
+			}
+			time.Sleep(tt.idle)
+			conn.Close()
+
+			if stats, _ := statsFor(p, address); stats.EvictedIdleTimeout != tt.evicted {
+				t.Errorf("%d idle timeout evictions, want %d", stats.EvictedIdleTimeout, tt.evicted)
+			}
+		})
+	}
+}
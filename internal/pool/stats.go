@@ -0,0 +1,41 @@
+package pool
+
+import "sort"
+
+// BackendStats describes the connections to one backend. Open counts every
+// established connection, split into those serving a request and idle ones,
+// spares included. The counters cover the lifetime of the backend in the
+// pool: Reused counts requests sent over an idle connection, Waits dials
+// that had to wait for a free slot, and the evictions count idle
+// connections closed by the idle timeout and by the maximum lifetime.
+type BackendStats struct {
+	Address            string `json:"address"`
+	Open               int    `json:"open"`
+	Idle               int    `json:"idle"`
+	InUse              int    `json:"in_use"`
+	Dials              uint64 `json:"dials"`
+	DialFailures       uint64 `json:"dial_failures"`
+	Reused             uint64 `json:"reused"`
+	Waits              uint64 `json:"waits"`
+	EvictedIdleTimeout uint64 `json:"evicted_idle_timeout"`
+	EvictedMaxLifetime uint64 `json:"evicted_max_lifetime"`
+}
+
+// Stats returns the connection statistics of every backend the pool has
+// connected to, ordered by address
+func (p *Pool) Stats() []BackendStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]BackendStats, 0, len(p.backends))
+	for address, backend := range p.backends {
+		s := backend.stats
+		s.Address = address
+		s.Open = len(backend.conns)
+		s.Idle = len(backend.conns) - backend.inUse
+		s.InUse = backend.inUse
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Address < stats[j].Address })
+	return stats
+}
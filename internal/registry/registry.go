@@ -1,23 +1,93 @@
 package registry
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 )
 
+// HealthState is the last known health of a backend
+type HealthState int
+
+const (
+	// HealthUnknown means the backend has not been checked yet
+	HealthUnknown HealthState = iota
+	// HealthHealthy means the backend passed its last health check
+	HealthHealthy
+	// HealthUnhealthy means the backend failed its last health check
+	HealthUnhealthy
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthHealthy:
+		return "healthy"
+	case HealthUnhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
 // Backend represents a server that can handle requests
 type Backend struct {
 	Address string
-	// Add more fields as needed (e.g., weight, capacity)
+	// Weight is the relative share of traffic for weighted strategies
+	Weight int `json:",omitempty"`
+	// HealthCheck names the health check used to probe the backend
+	HealthCheck string `json:",omitempty"`
+	// Disabled backends stay registered but receive no traffic
+	Disabled bool `json:",omitempty"`
+	// Draining backends receive no new traffic while in-flight requests finish
+	Draining bool `json:"-"`
+	// Drain records the drain in progress, so it resumes after a restart
+	Drain *Drain `json:",omitempty"`
+	// Health is maintained by the health checker and is not persisted
+	Health HealthState `json:"-"`
+}
+
+// Drain is the progress of draining a backend
+type Drain struct {
+	// StartedAt is when the backend started draining
+	StartedAt time.Time
+	// Deadline is when the backend is removed even with requests in flight
+	Deadline time.Time
+}
+
+// EffectiveWeight returns the backend's weight, treating an unset weight as 1
+func (b Backend) EffectiveWeight() int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+// EventType identifies how the set of registered backends changed
+type EventType int
+
+const (
+	// BackendAdded is sent when a backend that was not registered is added
+	BackendAdded EventType = iota
+	// BackendRemoved is sent when a backend is removed
+	BackendRemoved
+)
+
+// Event describes a change to the set of registered backends
+type Event struct {
+	Type    EventType
+	Backend Backend
 }
 
 // Registry manages a list of backend servers
 type Registry struct {
-	backends []Backend
-	mu       sync.RWMutex
-	filePath string
+	backends     []Backend
+	configured   []Backend
+	mu           sync.RWMutex
+	filePath     string
+	eventHandler func(Event)
 }
 
 // New creates and initializes a new Registry
@@ -30,25 +100,121 @@ func New(filePath string) *Registry {
 	return r
 }
 
-// Add appends a new backend to the registry
+// SetEventHandler sets a function that is called whenever a backend is
+// added to or removed from the registry. It is called without the
+// registry's lock held, so it may call back into the registry.
+func (r *Registry) SetEventHandler(handler func(Event)) {
+	r.eventHandler = handler
+}
+
+// notify passes an event to the event handler, if one is set
+func (r *Registry) notify(eventType EventType, backend Backend) {
+	if r.eventHandler != nil {
+		r.eventHandler(Event{Type: eventType, Backend: backend})
+	}
+}
+
+// Add appends a new backend to the registry, replacing any existing
+// entry with the same address
 func (r *Registry) Add(backend Backend) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	for i, b := range r.backends {
+		if b.Address == backend.Address {
+			// Keep the runtime state of the existing entry
+			backend.Health = b.Health
+			backend.Draining = b.Draining
+			backend.Drain = b.Drain
+			r.backends[i] = backend
+			r.save() // Save changes to file
+			r.mu.Unlock()
+			return
+		}
+	}
 	r.backends = append(r.backends, backend)
 	r.save() // Save changes to file
+	r.mu.Unlock()
+
+	r.notify(BackendAdded, backend)
 }
 
-// Remove deletes a backend from the registry based on its address
-func (r *Registry) Remove(address string) {
+// Remove deletes a backend from the registry based on its address and
+// reports whether it was registered
+func (r *Registry) Remove(address string) bool {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	for i, b := range r.backends {
 		if b.Address == address {
 			r.backends = append(r.backends[:i], r.backends[i+1:]...)
 			r.save() // Save changes to file
-			break
+			r.mu.Unlock()
+
+			r.notify(BackendRemoved, b)
+			return true
+		}
+	}
+	r.mu.Unlock()
+	return false
+}
+
+// Get returns the backend registered under address
+func (r *Registry) Get(address string) (Backend, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, b := range r.backends {
+		if b.Address == address {
+			return b, true
+		}
+	}
+	return Backend{}, false
+}
+
+// SetWeight changes the weight of a backend and reports whether it was found
+func (r *Registry) SetWeight(address string, weight int) bool {
+	return r.update(address, true, func(b *Backend) { b.Weight = weight })
+}
+
+// SetDisabled takes a backend out of rotation or puts it back, and reports
+// whether it was found
+func (r *Registry) SetDisabled(address string, disabled bool) bool {
+	return r.update(address, true, func(b *Backend) { b.Disabled = disabled })
+}
+
+// SetDraining marks a backend as draining with the given drain, or clears
+// the mark if drain is nil, and reports whether it was found
+func (r *Registry) SetDraining(address string, drain *Drain) bool {
+	return r.update(address, true, func(b *Backend) {
+		b.Draining = drain != nil
+		b.Drain = drain
+	})
+}
+
+// update applies fn to the backend registered under address, saving the
+// registry afterwards if persist is set, and reports whether it was found
+func (r *Registry) update(address string, persist bool, fn func(*Backend)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.backends {
+		if r.backends[i].Address == address {
+			fn(&r.backends[i])
+			if persist {
+				r.save() // Save changes to file
+			}
+			return true
 		}
 	}
+	return false
+}
+
+// SetHealth records the health state of a backend and returns its previous state
+func (r *Registry) SetHealth(address string, state HealthState) HealthState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, b := range r.backends {
+		if b.Address == address {
+			r.backends[i].Health = state
+			return b.Health
+		}
+	}
+	return HealthUnknown
 }
 
 // GetAll returns a copy of all backends in the registry
@@ -58,9 +224,32 @@ func (r *Registry) GetAll() []Backend {
 	return append([]Backend{}, r.backends...)
 }
 
+// Configured returns the backend list last applied from the configuration
+func (r *Registry) Configured() []Backend {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Backend{}, r.configured...)
+}
+
+// SetConfigured records the backend list applied from the configuration, so
+// the next one can be compared with it even after a restart
+func (r *Registry) SetConfigured(backends []Backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configured = append([]Backend{}, backends...)
+	r.save()
+}
+
+// registryFile is the layout of the registry file. Files written before
+// the configured list was recorded hold just the array of backends.
+type registryFile struct {
+	Backends   []Backend
+	Configured []Backend `json:",omitempty"`
+}
+
 // save writes the current state of the registry to a file
 func (r *Registry) save() error {
-	data, err := json.Marshal(r.backends)
+	data, err := json.Marshal(registryFile{Backends: r.backends, Configured: r.configured})
 	if err != nil {
 		return err
 	}
@@ -76,5 +265,17 @@ func (r *Registry) load() error {
 		}
 		return err
 	}
-	return json.Unmarshal(data, &r.backends)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return json.Unmarshal(data, &r.backends)
+	}
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	for i := range file.Backends {
+		file.Backends[i].Draining = file.Backends[i].Drain != nil
+	}
+	r.backends = append(r.backends, file.Backends...)
+	r.configured = file.Configured
+	return nil
 }
\ No newline at end of file
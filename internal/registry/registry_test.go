@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		backends   []Backend
+		configured []Backend
+	}{
+		{"no file", "", []Backend{}, []Backend{}},
+		{"backends only", `[{"Address":"a:1","Weight":2}]`, []Backend{{Address: "a:1", Weight: 2}}, []Backend{}},
+		{
+			"backends and configured list",
+			`{"Backends":[{"Address":"a:1","Disabled":true}],"Configured":[{"Address":"a:1","Weight":3}]}`,
+			[]Backend{{Address: "a:1", Disabled: true}},
+			[]Backend{{Address: "a:1", Weight: 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "registry.json")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := New(path)
+			if got := r.GetAll(); !reflect.DeepEqual(got, tt.backends) {
+				t.Errorf("backends = %+v, want %+v", got, tt.backends)
+			}
+			if got := r.Configured(); !reflect.DeepEqual(got, tt.configured) {
+				t.Errorf("configured = %+v, want %+v", got, tt.configured)
+			}
+		})
+	}
+}
+
+func TestSaveKeepsConfiguredList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r := New(path)
+	r.Add(Backend{Address: "a:1", Weight: 1})
+	r.Add(Backend{Address: "b:1"})
+	r.SetConfigured([]Backend{{Address: "a:1", Weight: 1}})
+	r.SetWeight("a:1", 5)
+
+	reloaded := New(path)
+	if got, want := reloaded.GetAll(), r.GetAll(); !reflect.DeepEqual(got, want) {
+		t.Errorf("backends after reload = %+v, want %+v", got, want)
+	}
+	if got, want := reloaded.Configured(), []Backend{{Address: "a:1", Weight: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("configured after reload = %+v, want %+v", got, want)
+	}
+}
+
+func TestSaveKeepsDrain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r := New(path)
+	r.Add(Backend{Address: "a:1"})
+	r.Add(Backend{Address: "b:1"})
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.SetDraining("a:1", &Drain{StartedAt: started, Deadline: started.Add(time.Minute)})
+
+	reloaded := New(path)
+	a, _ := reloaded.Get("a:1")
+	if !a.Draining || a.Drain == nil || !a.Drain.StartedAt.Equal(started) || !a.Drain.Deadline.Equal(started.Add(time.Minute)) {
+		t.Errorf("draining backend after reload = %+v, want its drain kept", a)
+	}
+	if b, _ := reloaded.Get("b:1"); b.Draining || b.Drain != nil {
+		t.Errorf("backend after reload = %+v, want it not draining", b)
+	}
+
+	reloaded.SetDraining("a:1", nil)
+	if a, _ := New(path).Get("a:1"); a.Draining || a.Drain != nil {
+		t.Errorf("backend after its drain was cleared = %+v, want it not draining", a)
+	}
+}
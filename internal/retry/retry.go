@@ -0,0 +1,189 @@
+package retry
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrRetryableStatus is returned from a proxy's ModifyResponse to turn a
+// response with a retryable status into an error, so it is discarded
+// instead of being sent to the client and the request can be retried
+var ErrRetryableStatus = errors.New("backend returned a retryable status")
+
+// budgetCapacity is how many retries the budget can hold. It starts full so
+// that retries work at low traffic, and bounds the burst of retries an
+// outage can cause before the percentage takes over.
+const budgetCapacity = 10
+
+// Policy decides which failed requests are sent again to another backend
+type Policy struct {
+	settings atomic.Pointer[settings]
+
+	mu     sync.Mutex
+	tokens float64 // retries the budget currently allows
+}
+
+// settings holds the parts of the Policy that can be changed by Reconfigure
+type settings struct {
+	maxAttempts   int
+	statusCodes   map[int]bool
+	budgetPercent float64
+	bufferBytes   int64
+}
+
+// Config holds the configuration for the Policy
+type Config struct {
+	// MaxAttempts caps how many backends a request is sent to, the first
+	// one included. 1 disables retries.
+	MaxAttempts int
+	// StatusCodes lists the backend responses that are retried. Connection
+	// failures are always retried.
+	StatusCodes []int
+	// BudgetPercent caps retries as a percentage of requests, so retries
+	// cannot multiply the load on a fleet that is already failing
+	BudgetPercent float64
+	// BufferBytes is the largest request body buffered in memory so the
+	// request can be sent again. Requests with a buffered body are retried
+	// whatever their method, and requests without a body only if their
+	// method is idempotent. Zero disables buffering, which leaves only
+	// idempotent requests without a body retryable.
+	BufferBytes int64
+}
+
+// New creates and initializes a new Policy
+func New(cfg Config) *Policy {
+	p := &Policy{tokens: budgetCapacity}
+	p.Reconfigure(cfg)
+	return p
+}
+
+// Reconfigure switches the policy to a new configuration while it is in
+// use. The retry budget carries over.
+func (p *Policy) Reconfigure(cfg Config) {
+	codes := make(map[int]bool, len(cfg.StatusCodes))
+	for _, code := range cfg.StatusCodes {
+		codes[code] = true
+	}
+	p.settings.Store(&settings{
+		maxAttempts:   cfg.MaxAttempts,
+		statusCodes:   codes,
+		budgetPercent: cfg.BudgetPercent,
+		bufferBytes:   cfg.BufferBytes,
+	})
+}
+
+// Prepare is called once for every incoming request before it is first
+// proxied. It adds the request's share to the retry budget, buffers its
+// body if it is small enough, and returns how many backends the request
+// may be sent to.
+func (p *Policy) Prepare(r *http.Request) (int, error) {
+	cfg := p.settings.Load()
+	p.deposit(cfg.budgetPercent / 100)
+
+	if cfg.maxAttempts <= 1 {
+		return 1, nil
+	}
+	buffered, err := bufferBody(r, cfg.bufferBytes)
+	if err != nil {
+		return 1, err
+	}
+	if buffered || (idempotent(r.Method) && !hasBody(r)) {
+		return cfg.maxAttempts, nil
+	}
+	return 1, nil
+}
+
+// RetryableStatus reports whether a response with the given status code
+// should be retried
+func (p *Policy) RetryableStatus(code int) bool {
+	return p.settings.Load().statusCodes[code]
+}
+
+// Allow takes a retry from the budget and reports whether there was one
+func (p *Policy) Allow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+// deposit adds to the retry budget, up to its capacity
+func (p *Policy) deposit(tokens float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tokens = min(p.tokens+tokens, budgetCapacity)
+}
+
+// Rewind resets the body of a request that is about to be sent again
+func Rewind(r *http.Request) error {
+	if r.GetBody == nil {
+		return nil
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+// IsConnectError reports whether err means the backend could not be
+// reached at all, so the request never got to it
+func IsConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// bufferBody reads the body of r into memory if it is no larger than limit
+// and arranges for it to be replayed with GetBody. A larger body is put
+// back together so the request can still be proxied once. A request without
+// a body is not buffered, so whether it is retried depends on its method.
+func bufferBody(r *http.Request, limit int64) (bool, error) {
+	if limit <= 0 || !hasBody(r) || r.ContentLength > limit {
+		return false, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(data)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return false, nil
+	}
+
+	r.Body.Close()
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return true, nil
+}
+
+// hasBody reports whether r carries a request body
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && (r.ContentLength != 0 || len(r.TransferEncoding) > 0)
+}
+
+// idempotent reports whether sending a request with the method twice has
+// the same effect as sending it once
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
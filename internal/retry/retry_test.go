@@ -0,0 +1,142 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		maxAttempts int
+		bufferBytes int64
+		attempts    int
+	}{
+		{"GET without body", "GET", "", 3, 0, 3},
+		{"DELETE without body", "DELETE", "", 3, 0, 3},
+		{"POST without body", "POST", "", 3, 1024, 1},
+		{"POST with buffered body", "POST", "payload", 3, 1024, 3},
+		{"POST with body over the limit", "POST", "payload", 3, 4, 1},
+		{"PUT with body and buffering disabled", "PUT", "payload", 3, 0, 1},
+		{"PUT with buffered body", "PUT", "payload", 3, 1024, 3},
+		{"retries disabled", "GET", "", 1, 1024, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(Config{MaxAttempts: tt.maxAttempts, BudgetPercent: 20, BufferBytes: tt.bufferBytes})
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			r := httptest.NewRequest(tt.method, "/", body)
+
+			attempts, err := p.Prepare(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("Prepare allowed %d attempts, want %d", attempts, tt.attempts)
+			}
+
+			// Whether or not it was buffered, the body must reach the
+			// backend intact, and again after Rewind if it is retried
+			for send := 1; send <= min(attempts, 2); send++ {
+				if send > 1 {
+					if err := Rewind(r); err != nil {
+						t.Fatal(err)
+					}
+				}
+				got, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.body {
+					t.Errorf("send %d: body = %q, want %q", send, got, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		percent  float64
+		spent    bool // spend the initial budget before the requests
+		requests int
+		allowed  int
+	}{
+		{"starts full", 20, false, 0, budgetCapacity},
+		{"refills by the percentage", 50, true, 4, 2},
+		{"refills up to capacity", 100, true, 2 * budgetCapacity, budgetCapacity},
+		{"no refill at zero percent", 0, true, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(Config{MaxAttempts: 2, BudgetPercent: tt.percent})
+			if tt.spent {
+				for p.Allow() {
+				}
+			}
+			for i := 0; i < tt.requests; i++ {
+				if _, err := p.Prepare(httptest.NewRequest("GET", "/", nil)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			allowed := 0
+			for p.Allow() {
+				allowed++
+			}
+			if allowed != tt.allowed {
+				t.Errorf("budget allowed %d retries, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRetryableStatus(t *testing.T) {
+	p := New(Config{MaxAttempts: 2, StatusCodes: []int{502, 503}})
+
+	for code, want := range map[int]bool{200: false, 500: false, 502: true, 503: true, 504: false} {
+		if got := p.RetryableStatus(code); got != want {
+			t.Errorf("RetryableStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+
+	// Reconfigure replaces the list
+	p.Reconfigure(Config{MaxAttempts: 2, StatusCodes: []int{504}})
+	if p.RetryableStatus(502) || !p.RetryableStatus(504) {
+		t.Error("Reconfigure did not replace the retryable status codes")
+	}
+}
+
+func TestIsConnectError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dial error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"wrapped dial error", errors.Join(errors.New("proxy"), &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"read error", &net.OpError{Op: "read", Err: errors.New("connection reset")}, false},
+		{"other error", io.ErrUnexpectedEOF, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConnectError(tt.err); got != tt.want {
+				t.Errorf("IsConnectError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
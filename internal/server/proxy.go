@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/pool"
+	"simple_load_balancer/internal/registry"
+	"simple_load_balancer/internal/retry"
+)
+
+// proxyCache holds one reverse proxy per backend. All proxies share a single
+// transport, which dials through the connection pool and keeps connections
+// to each backend idle for reuse.
+type proxyCache struct {
+	newProxy func(address string, transport http.RoundTripper) *httputil.ReverseProxy
+	pool     *pool.Pool
+
+	mu        sync.RWMutex
+	transport *http.Transport
+	proxies   map[string]*httputil.ReverseProxy
+}
+
+// newProxyCache creates a cache whose proxies are built by newProxy
+func newProxyCache(p *pool.Pool, cfg pool.PoolConfig, newProxy func(string, http.RoundTripper) *httputil.ReverseProxy) *proxyCache {
+	return &proxyCache{
+		newProxy:  newProxy,
+		pool:      p,
+		transport: newTransport(p, cfg),
+		proxies:   make(map[string]*httputil.ReverseProxy),
+	}
+}
+
+// get returns the proxy for a backend, creating it on first use
+func (c *proxyCache) get(address string) *httputil.ReverseProxy {
+	c.mu.RLock()
+	proxy, ok := c.proxies[address]
+	c.mu.RUnlock()
+	if ok {
+		return proxy
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if proxy, ok = c.proxies[address]; !ok {
+		proxy = c.newProxy(address, c.transport)
+		c.proxies[address] = proxy
+	}
+	return proxy
+}
+
+// remove forgets the proxy for a backend that is no longer registered and
+// closes its idle connections
+func (c *proxyCache) remove(address string) {
+	c.mu.Lock()
+	delete(c.proxies, address)
+	c.mu.Unlock()
+
+	c.pool.Remove(address)
+}
+
+// reconfigure switches to a new transport built from cfg. Requests already
+// running finish on the old transport, whose idle connections are closed.
+func (c *proxyCache) reconfigure(cfg pool.PoolConfig) {
+	c.mu.Lock()
+	old := c.transport
+	c.transport = newTransport(c.pool, cfg)
+	c.proxies = make(map[string]*httputil.ReverseProxy)
+	c.mu.Unlock()
+
+	old.CloseIdleConnections()
+}
+
+// close closes the idle connections of the shared transport
+func (c *proxyCache) close() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.transport.CloseIdleConnections()
+}
+
+// newTransport builds the transport shared by all backend proxies. It dials
+// through the pool, which caps and tracks the connections to each backend
+// and closes expired idle ones, and keeps up to the pool's limit idle. The
+// transport closes idle connections too, one cleanup interval after the
+// pool would, so connections the pool no longer sees do not stay open
+// forever without it racing the pool's evictions.
+func newTransport(p *pool.Pool, cfg pool.PoolConfig) *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           p.DialContext,
+		MaxIdleConnsPerHost:   cfg.MaxConns,
+		IdleConnTimeout:       cfg.IdleTimeout + cfg.CleanupInterval,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// requestStartKey is the context key for when a request was sent to its backend
+type requestStartKey struct{}
+
+// attempt tracks a request across the backends it is sent to. The backend
+// proxy's handlers find it in the request context and set next when an
+// attempt fails in a way that is retried.
+type attempt struct {
+	tried []string // backends already sent the request, the current one included
+	left  int      // retries still allowed by the policy
+	next  *registry.Backend
+	err   error // why the last attempt failed
+}
+
+// attemptKey is the context key for the request's *attempt
+type attemptKey struct{}
+
+// retryElsewhere decides whether the failed attempt in ctx is retried,
+// picking the backend for the next one. A request is never sent to the same
+// backend twice, and each retry is taken from the retry budget.
+func (s *Server) retryElsewhere(ctx context.Context, err error) bool {
+	a, ok := ctx.Value(attemptKey{}).(*attempt)
+	if !ok || a.left <= 0 {
+		return false
+	}
+	next := s.balancer.RetryBackend(a.tried)
+	if next == nil || !s.retries.Allow() {
+		return false
+	}
+	a.next = next
+	a.err = err
+	return true
+}
+
+// newBackendProxy builds the reverse proxy for one backend. It feeds the
+// time to response headers and the load the backend reports into the
+// balancer, and the outcome into the outlier detector.
+func (s *Server) newBackendProxy(address string, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: address})
+	proxy.Transport = transport
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if start, ok := resp.Request.Context().Value(requestStartKey{}).(time.Time); ok {
+			s.balancer.ObserveLatency(address, time.Since(start))
+		}
+		s.recordReportedLoad(address, resp)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			s.outliers.ReportFailure(address)
+		} else {
+			s.outliers.ReportSuccess(address)
+		}
+		// Discard the response so the request can be sent elsewhere
+		if s.retries.RetryableStatus(resp.StatusCode) && s.retryElsewhere(resp.Request.Context(), fmt.Errorf("status %d", resp.StatusCode)) {
+			return retry.ErrRetryableStatus
+		}
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// The retry has already been arranged by ModifyResponse
+		if errors.Is(err, retry.ErrRetryableStatus) {
+			return
+		}
+		// Running out of connections is our limit, not a backend failure,
+		// and neither is the backend being removed while we waited for one
+		if errors.Is(err, pool.ErrPoolExhausted) || errors.Is(err, pool.ErrBackendRemoved) {
+			if s.retryElsewhere(r.Context(), err) {
+				return
+			}
+			logging.Warnf("No connection available to backend %s: %v", address, err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// A client that gave up is not the backend's fault
+		if !errors.Is(err, context.Canceled) {
+			s.outliers.ReportFailure(address)
+		}
+		// Only a request that never reached the backend is retried, since
+		// it may have acted on one that failed later
+		if retry.IsConnectError(err) && s.retryElsewhere(r.Context(), err) {
+			return
+		}
+		logging.Errorf("Proxy error from backend %s: %v", address, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return proxy
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"simple_load_balancer/config"
+	"simple_load_balancer/internal/balancer"
+	"simple_load_balancer/internal/health"
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/registry"
+)
+
+// liveSettings are the configuration fields Reload applies to the running
+// server. Changes to any other field only take effect after a restart.
+var liveSettings = map[string]bool{
+	"shutdown_timeout":         true,
+	"pool_max_conns":           true,
+	"pool_idle_timeout":        true,
+	"pool_max_lifetime":        true,
+	"pool_cleanup_interval":    true,
+	"pool_wait_timeout":        true,
+	"pool_min_idle":            true,
+	"retry_max_attempts":       true,
+	"retry_on_status":          true,
+	"retry_budget_percent":     true,
+	"retry_buffer_bytes":       true,
+	"health_check_interval":    true,
+	"health_check_timeout":     true,
+	"health_check_endpoint":    true,
+	"health_checks":            true,
+	"health_check_rise":        true,
+	"health_check_fall":        true,
+	"health_check_max_backoff": true,
+	"health_check_jitter":      true,
+	"balancer_algorithm":       true,
+	"hash_key":                 true,
+	"hash_virtual_nodes":       true,
+	"load_header":              true,
+	"load_stale_after":         true,
+	"log_level":                true,
+	"log_format":               true,
+	"backend_servers":          true,
+}
+
+// Reload applies a new configuration to the running server. Settings that
+// can change live are applied; the rest are logged as needing a restart.
+// If cfg is rejected by any component nothing is changed and the current
+// configuration keeps running.
+func (s *Server) Reload(cfg *config.Config) error {
+	current := s.config.Load()
+	changed := config.Changed(current, cfg)
+	if len(changed) == 0 {
+		logging.Infof("Configuration unchanged")
+		return nil
+	}
+
+	// Build everything that can be rejected before changing anything, so a
+	// bad file leaves the running configuration untouched
+	if err := logging.Validate(LoggingConfig(cfg)); err != nil {
+		return err
+	}
+	if _, err := balancer.New(s.registry, balancerConfig(cfg)); err != nil {
+		return fmt.Errorf("balancer: %v", err)
+	}
+	if _, err := health.New(s.registry, healthConfig(cfg)); err != nil {
+		return fmt.Errorf("health checker: %v", err)
+	}
+
+	var restart []string
+	for _, name := range changed {
+		if !liveSettings[name] {
+			restart = append(restart, name)
+		}
+	}
+
+	logging.Configure(LoggingConfig(cfg))
+	s.balancer.Reconfigure(balancerConfig(cfg))
+	s.health.Reconfigure(healthConfig(cfg))
+	s.pool.Reconfigure(poolConfig(cfg))
+	s.retries.Reconfigure(retryConfig(cfg))
+	// The transport only needs rebuilding when its idle limits change; the
+	// pool applies everything else to the connections it hands out
+	if cfg.PoolMaxConns != current.PoolMaxConns || cfg.PoolIdleTimeout != current.PoolIdleTimeout ||
+		cfg.PoolCleanupInterval != current.PoolCleanupInterval {
+		s.proxies.reconfigure(poolConfig(cfg))
+	}
+	if slices.Contains(changed, "backend_servers") {
+		s.reloadBackends(cfg.BackendServers, time.Duration(current.DrainTimeout))
+	}
+
+	// Settings that need a restart keep their running values until then
+	for _, name := range restart {
+		logging.Warnf("Configuration change to %s requires a restart", name)
+	}
+	next := *cfg
+	next.CopyFrom(current, restart)
+	s.config.Store(&next)
+
+	logging.Infof("Configuration reloaded (changed: %v)", changed)
+	return nil
+}
+
+// reloadBackends applies the difference between the backend list last
+// applied from the configuration and the configured one to the registry.
+// Backends added or changed in the configuration are registered, and
+// backends removed from it are drained. Changes made through the admin API
+// are kept until the configuration of that backend changes.
+func (s *Server) reloadBackends(backends []config.BackendServer, drainTimeout time.Duration) {
+	previous := make(map[string]registry.Backend)
+	for _, backend := range s.registry.Configured() {
+		previous[backend.Address] = backend
+	}
+
+	configured := make([]registry.Backend, 0, len(backends))
+	for _, backend := range backends {
+		entry := registry.Backend{
+			Address:     backend.Address,
+			Weight:      backend.Weight,
+			HealthCheck: backend.HealthCheck,
+		}
+		configured = append(configured, entry)
+		if existing, ok := previous[backend.Address]; ok {
+			delete(previous, backend.Address)
+			if existing == entry {
+				continue
+			}
+		}
+
+		if registered, ok := s.registry.Get(backend.Address); ok {
+			entry.Disabled = registered.Disabled
+			// A backend put back into the configuration while it drains
+			// must not be removed when the drain finishes
+			if registered.Draining && s.balancer.CancelDrain(backend.Address) {
+				logging.Infof("Backend %s is configured again, cancelled its drain", backend.Address)
+			}
+		} else {
+			logging.Infof("Adding backend %s", backend.Address)
+		}
+		s.registry.Add(entry)
+	}
+	s.registry.SetConfigured(configured)
+
+	for address := range previous {
+		if s.balancer.Drain(address, drainTimeout) {
+			logging.Infof("Draining backend %s removed from the configuration", address)
+		}
+	}
+}
@@ -1,36 +1,52 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.mongodb.org/mongo-driver/mongo"
-		
+
 	"simple_load_balancer/config"
-	"simple_load_balancer/internal/registry"
+	"simple_load_balancer/internal/admin"
 	"simple_load_balancer/internal/balancer"
-	"simple_load_balancer/internal/pool"
+	controller "simple_load_balancer/internal/controller"
+	"simple_load_balancer/internal/database"
 	"simple_load_balancer/internal/health"
 	"simple_load_balancer/internal/listener"
-	"simple_load_balancer/internal/database"
-	controller "simple_load_balancer/internal/controller"
+	"simple_load_balancer/internal/logging"
+	"simple_load_balancer/internal/outlier"
+	"simple_load_balancer/internal/pool"
+	"simple_load_balancer/internal/registry"
+	"simple_load_balancer/internal/retry"
+	"simple_load_balancer/internal/upgrade"
 )
 
 // Server represents the main load balancer server structure
 type Server struct {
-	config   *config.Config
+	// config is replaced as a whole by Reload
+	config   atomic.Pointer[config.Config]
 	registry *registry.Registry
 	balancer *balancer.Balancer
 	pool     *pool.Pool
+	proxies  *proxyCache
+	retries  *retry.Policy
 	health   *health.HealthChecker
+	outliers *outlier.Detector
 	listener *listener.Listener
+	admin    *admin.Server
 	router   *chi.Mux
 	db       *mongo.Database
+
+	// inFlight counts requests being served, for reporting at shutdown
+	inFlight atomic.Int64
+	done     chan struct{}
 }
 
 // New creates and initializes a new Server instance
@@ -40,46 +56,138 @@ func New(cfg *config.Config) *Server {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 	reg := registry.New(cfg.RegistryFile)
-	bal := balancer.New(reg)
+	bal, err := balancer.New(reg, balancerConfig(cfg))
+	if err != nil {
+		log.Fatalf("Failed to create balancer: %v", err)
+	}
 	listenerConfig := listener.Config{
-		Address:     cfg.ListenAddr,
-		TLSCertFile: cfg.TLSCertFile,
-		TLSKeyFile:  cfg.TLSKeyFile,
-		IdleTimeout: time.Duration(cfg.PoolIdleTimeout),
+		Address:           cfg.ListenAddr,
+		TLSCertFile:       cfg.TLSCertFile,
+		TLSKeyFile:        cfg.TLSKeyFile,
+		ReadTimeout:       time.Duration(cfg.HTTPReadTimeout),
+		ReadHeaderTimeout: time.Duration(cfg.HTTPReadHeaderTimeout),
+		WriteTimeout:      time.Duration(cfg.HTTPWriteTimeout),
+		IdleTimeout:       time.Duration(cfg.HTTPIdleTimeout),
+		MaxHeaderBytes:    cfg.HTTPMaxHeaderBytes,
 	}
 	lis, err := listener.New(listenerConfig)
 	if err != nil {
 		log.Fatalf("Failed to create listener: %v", err)
 	}
-	poolConfig := pool.PoolConfig{
-		MaxConns:        cfg.PoolMaxConns,
-		IdleTimeout:     time.Duration(cfg.PoolIdleTimeout),
-		MaxLifetime:     time.Duration(cfg.PoolMaxLifetime),
-		CleanupInterval: time.Duration(cfg.PoolCleanupInterval),
+	healthChecker, err := health.New(reg, healthConfig(cfg))
+	if err != nil {
+		log.Fatalf("Failed to create health checker: %v", err)
 	}
+	connPool := pool.New(poolConfig(cfg))
 	s := &Server{
 		router:   chi.NewRouter(),
 		db:       db,
-		config:   cfg,
+		done:     make(chan struct{}),
 		registry: reg,
 		balancer: bal,
-		pool:     pool.New(poolConfig),
-		health: health.New(
-			reg,
-			time.Duration(cfg.HealthCheckInterval),
-			time.Duration(cfg.HealthCheckTimeout),
-			cfg.HealthCheckEndpoint,
-		),
+		pool:     connPool,
+		retries:  retry.New(retryConfig(cfg)),
+		health:   healthChecker,
+		outliers: outlier.New(reg, outlier.Config{
+			ConsecutiveErrors:  cfg.OutlierConsecutiveErrors,
+			BaseEjectionTime:   time.Duration(cfg.OutlierBaseEjectionTime),
+			MaxEjectionTime:    time.Duration(cfg.OutlierMaxEjectionTime),
+			MaxEjectionPercent: cfg.OutlierMaxEjectionPercent,
+		}),
 		listener: lis,
+		admin: admin.New(admin.Config{
+			Address:      cfg.AdminAddr,
+			Token:        cfg.AdminToken,
+			DrainTimeout: time.Duration(cfg.DrainTimeout),
+		}, reg, bal, healthChecker, connPool),
 	}
-	lis.SetHandler(s.handleConnection)
+	s.config.Store(cfg)
+	s.proxies = newProxyCache(s.pool, poolConfig(cfg), s.newBackendProxy)
+	reg.SetEventHandler(s.handleRegistryEvent)
+	bal.SetOutlierDetector(s.outliers)
+	s.health.SetResultHandler(s.handleHealthResult)
+	lis.SetHandler(s.router)
 	s.setupRoutes()
 	return s
 }
 
+// balancerConfig extracts the balancer settings from the configuration
+func balancerConfig(cfg *config.Config) balancer.Config {
+	return balancer.Config{
+		Algorithm:      cfg.BalancerAlgorithm,
+		HashKey:        cfg.HashKey,
+		VirtualNodes:   cfg.HashVirtualNodes,
+		LoadStaleAfter: time.Duration(cfg.LoadStaleAfter),
+	}
+}
+
+// retryConfig extracts the retry policy settings from the configuration
+func retryConfig(cfg *config.Config) retry.Config {
+	return retry.Config{
+		MaxAttempts:   cfg.RetryMaxAttempts,
+		StatusCodes:   cfg.RetryOnStatus,
+		BudgetPercent: cfg.RetryBudgetPercent,
+		BufferBytes:   int64(cfg.RetryBufferBytes),
+	}
+}
+
+// poolConfig extracts the connection pool settings from the configuration
+func poolConfig(cfg *config.Config) pool.PoolConfig {
+	return pool.PoolConfig{
+		MaxConns:        cfg.PoolMaxConns,
+		MinIdle:         cfg.PoolMinIdle,
+		IdleTimeout:     time.Duration(cfg.PoolIdleTimeout),
+		MaxLifetime:     time.Duration(cfg.PoolMaxLifetime),
+		CleanupInterval: time.Duration(cfg.PoolCleanupInterval),
+		WaitTimeout:     time.Duration(cfg.PoolWaitTimeout),
+	}
+}
+
+// healthConfig extracts the health checker settings from the configuration
+func healthConfig(cfg *config.Config) health.Config {
+	return health.Config{
+		Interval:   time.Duration(cfg.HealthCheckInterval),
+		Timeout:    time.Duration(cfg.HealthCheckTimeout),
+		Checks:     healthChecks(cfg.HealthChecks),
+		Rise:       cfg.HealthCheckRise,
+		Fall:       cfg.HealthCheckFall,
+		MaxBackoff: time.Duration(cfg.HealthCheckMaxBackoff),
+		Jitter:     cfg.HealthCheckJitter,
+	}
+}
+
+// LoggingConfig extracts the logging settings from the configuration
+func LoggingConfig(cfg *config.Config) logging.Config {
+	return logging.Config{Level: cfg.LogLevel, Format: cfg.LogFormat}
+}
+
+// healthChecks converts the configured health checks into health checker settings
+func healthChecks(checks map[string]config.HealthCheck) map[string]health.CheckConfig {
+	converted := make(map[string]health.CheckConfig, len(checks))
+	for name, check := range checks {
+		converted[name] = health.CheckConfig{
+			Type:                  check.Type,
+			Path:                  check.Path,
+			Method:                check.Method,
+			Scheme:                check.Scheme,
+			ExpectedStatus:        check.ExpectedStatus,
+			Headers:               check.Headers,
+			BodyContains:          check.BodyContains,
+			BodyRegex:             check.BodyRegex,
+			JSONPath:              check.JSONPath,
+			JSONValue:             check.JSONValue,
+			GRPCService:           check.GRPCService,
+			GRPCTLS:               check.GRPCTLS,
+			TLSCAFile:             check.TLSCAFile,
+			TLSInsecureSkipVerify: check.TLSInsecureSkipVerify,
+		}
+	}
+	return converted
+}
+
 // Start initializes the server components and begins the main server loop
 func (s *Server) Start() error {
-	log.Println("Starting load balancer...")
+	logging.Infof("Starting load balancer...")
 
 	// Register backend servers
 	s.registerBackends()
@@ -90,38 +198,87 @@ func (s *Server) Start() error {
 	// Start periodic logging of server loads
 	go s.logServerLoads()
 
-	// Start the listener
-	return s.listener.Start()
-}
+	// Open the proxy and admin sockets, taking them over from the previous
+	// process if this one was started by a binary upgrade
+	if err := s.listener.Listen(); err != nil {
+		return err
+	}
+	if err := s.admin.Listen(); err != nil {
+		return err
+	}
 
-// handleConnection processes a single client connection
-func (s *Server) handleConnection(clientConn net.Conn) {
-	defer clientConn.Close()
+	// Serve the admin API on its own listener
+	go func() {
+		if err := s.admin.Serve(); err != nil {
+			log.Fatalf("Admin API failed: %v", err)
+		}
+	}()
 
-	// Create a new http.Server with our router
-	httpServer := &http.Server{
-		Handler: s.router,
+	// Now that we are accepting connections, let the previous process drain
+	if err := upgrade.Ready(); err != nil {
+		logging.Errorf("Failed to notify previous process: %v", err)
 	}
 
-	// Serve the connection
-	httpServer.Serve(&singleConnListener{clientConn})
+	// Start the listener
+	return s.listener.Serve()
 }
 
-// singleConnListener is a helper type that wraps a single connection
-type singleConnListener struct {
-	conn net.Conn
-}
+// Upgrade starts a new copy of the load balancer binary that takes over the
+// listening sockets. Once it is ready it sends this process SIGTERM, which
+// drains it through the normal graceful shutdown.
+func (s *Server) Upgrade() error {
+	listeners := map[string]net.Listener{
+		"listen": s.listener.Socket(),
+		"admin":  s.admin.Socket(),
+	}
+	for name, l := range listeners {
+		if l == nil {
+			return fmt.Errorf("%s listener is not open", name)
+		}
+	}
 
-func (l *singleConnListener) Accept() (net.Conn, error) {
-	return l.conn, nil
+	_, err := upgrade.Upgrade(listeners)
+	return err
 }
 
-func (l *singleConnListener) Close() error {
-	return nil
+// Shutdown stops accepting connections, waits for in-flight requests to
+// finish until ctx expires, and then releases the server's resources
+func (s *Server) Shutdown(ctx context.Context) error {
+	logging.Infof("Shutting down load balancer...")
+
+	// Stop accepting new connections and wait for in-flight requests to
+	// finish, then do the same for the admin API
+	err := s.listener.Shutdown(ctx)
+	if err != nil {
+		logging.Warnf("Shutdown timeout with %d requests still in flight", s.inFlight.Load())
+	}
+	if err := s.admin.Shutdown(ctx); err != nil {
+		logging.Errorf("Error shutting down admin API: %v", err)
+	}
+
+	// Stop background work and release resources
+	close(s.done)
+	s.health.Stop()
+	s.proxies.close()
+	s.pool.Close()
+
+	disconnectCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if dbErr := s.db.Client().Disconnect(disconnectCtx); dbErr != nil {
+		logging.Errorf("Error disconnecting from MongoDB: %v", dbErr)
+	}
+
+	logging.Infof("Load balancer stopped")
+	return err
 }
 
-func (l *singleConnListener) Addr() net.Addr {
-	return l.conn.LocalAddr()
+// trackInFlight counts the requests being served
+func (s *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
 }
 
 // logServerLoads periodically logs the current load of all servers
@@ -129,9 +286,14 @@ func (s *Server) logServerLoads() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		loads := s.balancer.GetServerLoads()
-		log.Printf("Current server loads: %v", loads)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			loads := s.balancer.GetServerLoads()
+			logging.Debugf("Current server loads: %v", loads)
+		}
 	}
 }
 
@@ -143,6 +305,8 @@ func (s *Server) UpdateBackendLoad(address string, load float64) {
 func (s *Server) setupRoutes() {
 	userController := controller.NewUserController(s.db)
 
+	s.router.Use(s.trackInFlight)
+
 	s.router.Post("/users", userController.AddUser)
 	s.router.Get("/users/last", userController.GetLastUser)
 
@@ -151,25 +315,110 @@ func (s *Server) setupRoutes() {
 }
 
 func (s *Server) forwardToBackend(w http.ResponseWriter, r *http.Request) {
-	backend := s.balancer.NextBackend()
+	attempts, err := s.retries.Prepare(r)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	backend := s.balancer.NextBackend(r)
 	if backend == nil {
 		http.Error(w, "No available backend servers", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Create a reverse proxy
-	backendURL, err := url.Parse("http://" + backend.Address)
+	// The backend proxy's handlers set a.next when an attempt fails in a way
+	// that is retried on another backend
+	a := &attempt{left: attempts - 1}
+	for {
+		a.tried = append(a.tried, backend.Address)
+		a.next = nil
+		s.proxyTo(backend.Address, w, r, a)
+		if a.next == nil {
+			return
+		}
+
+		logging.Warnf("Retrying %s %s on backend %s after backend %s failed: %v", r.Method, r.URL.Path, a.next.Address, backend.Address, a.err)
+		if err := retry.Rewind(r); err != nil {
+			logging.Errorf("Error rewinding request body: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		backend = a.next
+		a.left--
+	}
+}
+
+// proxyTo sends one attempt at r to a backend
+func (s *Server) proxyTo(address string, w http.ResponseWriter, r *http.Request, a *attempt) {
+	// Track the request as in-flight until the response has been fully
+	// written or the proxy has given up on the backend
+	s.balancer.Acquire(address)
+	defer s.balancer.Release(address)
+
+	ctx := context.WithValue(s.pool.WithTrace(r.Context()), requestStartKey{}, time.Now())
+	ctx = context.WithValue(ctx, attemptKey{}, a)
+	s.proxies.get(address).ServeHTTP(w, r.WithContext(ctx))
+}
+
+// recordReportedLoad ingests the load a backend reports in its response
+// headers and strips the header so it is not leaked to clients
+func (s *Server) recordReportedLoad(address string, resp *http.Response) {
+	header := s.config.Load().LoadHeader
+	value := resp.Header.Get(header)
+	if value == "" {
+		return
+	}
+	resp.Header.Del(header)
+
+	load, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		http.Error(w, "Error parsing backend URL", http.StatusInternalServerError)
+		logging.Warnf("Ignoring invalid %s header %q from backend %s", header, value, address)
 		return
 	}
+	s.balancer.UpdateServerLoad(address, load)
+}
 
-	proxy := httputil.NewSingleHostReverseProxy(backendURL)
-	proxy.ServeHTTP(w, r)
+// handleHealthResult feeds the outcome of an active health check into the
+// balancer and the outlier detector
+func (s *Server) handleHealthResult(backend registry.Backend, result health.HealthCheckResult) {
+	if result.Healthy {
+		s.balancer.ObserveLatency(backend.Address, result.Latency)
+		// A backend ejected for failing live traffic is put back as soon as
+		// it passes an active check, even if its health state never changed
+		s.outliers.Restore(backend.Address)
+	}
+	if result.LoadReported {
+		s.balancer.UpdateServerLoad(backend.Address, result.Load)
+	}
 }
 
+// handleRegistryEvent pre-dials connections to a backend when it is added
+// and drops its cached proxy and idle connections once it has been removed
+func (s *Server) handleRegistryEvent(event registry.Event) {
+	address := event.Backend.Address
+	switch event.Type {
+	case registry.BackendAdded:
+		if s.config.Load().PoolMinIdle > 0 {
+			go func() {
+				if err := s.pool.Warm(address); err != nil {
+					logging.Errorf("Error pre-dialing connections to backend %s: %v", address, err)
+				}
+			}()
+		}
+	case registry.BackendRemoved:
+		s.proxies.remove(address)
+	}
+}
+
+// registerBackends resumes the drains recorded in the registry file and
+// applies the changes made to the configured backends since it was last
+// written, the same way a reload does
 func (s *Server) registerBackends() {
-	for _, backendAddr := range s.config.BackendServers {
-		s.registry.Add(registry.Backend{Address: backendAddr})
+	cfg := s.config.Load()
+	if existing := s.registry.GetAll(); len(existing) > 0 {
+		logging.Infof("Loaded %d backends from registry file %s", len(existing), cfg.RegistryFile)
 	}
-}
\ No newline at end of file
+	s.balancer.ResumeDrains()
+	s.reloadBackends(cfg.BackendServers, time.Duration(cfg.DrainTimeout))
+}
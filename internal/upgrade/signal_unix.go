@@ -0,0 +1,14 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Notify relays upgrade requests (SIGUSR2) to c
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
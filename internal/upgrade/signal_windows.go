@@ -0,0 +1,11 @@
+//go:build windows
+
+package upgrade
+
+import (
+	"os"
+)
+
+// Notify does nothing on Windows, which has no SIGUSR2 and cannot pass
+// listening sockets to a child process
+func Notify(c chan<- os.Signal) {}
@@ -0,0 +1,165 @@
+package upgrade
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"simple_load_balancer/internal/logging"
+)
+
+const (
+	// envInheritedFDs lists the listeners passed to a new process as
+	// name=fd pairs, e.g. "listen=3,admin=4"
+	envInheritedFDs = "LB_INHERITED_FDS"
+	// envParentPID holds the PID of the process that started the upgrade,
+	// which is told to shut down once the new process is ready
+	envParentPID = "LB_UPGRADE_PARENT_PID"
+)
+
+var (
+	inheritOnce sync.Once
+	inherited   map[string]net.Listener
+	inheritErr  error
+)
+
+// Listen returns the listener named name that was inherited from the
+// process that started this one, or opens a new TCP listener on address
+// if there is none
+func Listen(name, address string) (net.Listener, error) {
+	inheritOnce.Do(func() {
+		inherited, inheritErr = inheritListeners()
+	})
+	if inheritErr != nil {
+		return nil, inheritErr
+	}
+
+	if l, ok := inherited[name]; ok {
+		delete(inherited, name)
+		logging.Infof("Using inherited %s listener on %s", name, l.Addr())
+		return l, nil
+	}
+	return net.Listen("tcp", address)
+}
+
+// inheritListeners rebuilds the listeners described by envInheritedFDs
+func inheritListeners() (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+
+	spec := os.Getenv(envInheritedFDs)
+	if spec == "" {
+		return listeners, nil
+	}
+	os.Unsetenv(envInheritedFDs)
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, fdString, ok := strings.Cut(pair, "=")
+		fd, err := strconv.Atoi(fdString)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q", envInheritedFDs, pair)
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(file)
+		file.Close() // FileListener holds its own copy of the descriptor
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit %s listener from fd %d: %v", name, fd, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// Upgrade starts a new copy of the running executable, with the same
+// arguments, that inherits the given listeners. The new process calls
+// Ready once it is accepting connections, which asks this process to
+// shut down.
+func Upgrade(listeners map[string]net.Listener) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	var fds []string
+	for name, l := range listeners {
+		fileListener, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("%s listener does not support file descriptor handoff", name)
+		}
+		file, err := fileListener.File()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s listener file: %v", name, err)
+		}
+		files = append(files, file)
+		// ExtraFiles start at fd 3, after stdin, stdout and stderr
+		fds = append(fds, fmt.Sprintf("%s=%d", name, 2+len(files)))
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(environWithout(envInheritedFDs, envParentPID),
+		envInheritedFDs+"="+strings.Join(fds, ","),
+		envParentPID+"="+strconv.Itoa(os.Getpid()),
+	)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	logging.Infof("Started upgraded process %d", cmd.Process.Pid)
+	return cmd.Process, nil
+}
+
+// Ready tells the process that started an upgrade, if any, that this
+// process is accepting connections and it can shut down
+func Ready() error {
+	pidString := os.Getenv(envParentPID)
+	if pidString == "" {
+		return nil
+	}
+	os.Unsetenv(envParentPID)
+
+	pid, err := strconv.Atoi(pidString)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", envParentPID, pidString)
+	}
+	parent, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	logging.Infof("Upgrade complete, asking previous process %d to shut down", pid)
+	return parent.Signal(syscall.SIGTERM)
+}
+
+// environWithout returns the environment minus the named variables
+func environWithout(names ...string) []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		keep := true
+		for _, excluded := range names {
+			if name == excluded {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			env = append(env, entry)
+		}
+	}
+	return env
+}